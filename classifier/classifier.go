@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/nikhil1raghav/kindle-send/epubgen"
 	"github.com/nikhil1raghav/kindle-send/types"
 )
 
@@ -54,11 +55,24 @@ func isBook(u string) bool {
 	return false
 }
 
+func isTranscript(u string) bool {
+	extension := strings.ToLower(filepath.Ext(u))
+	if extension != ".vtt" && extension != ".srt" {
+		return false
+	}
+	info, err := os.Stat(u)
+	return err == nil && !info.IsDir()
+}
+
 func Classify(args []string) []types.Request {
 	var requests []types.Request
 	for _, arg := range args {
-		if isUrl(arg) {
+		if epubgen.IsRepo(arg) {
+			requests = append(requests, types.NewRequest(arg, types.TypeRepo, nil))
+		} else if isUrl(arg) {
 			requests = append(requests, types.NewRequest(arg, types.TypeUrl, nil))
+		} else if isTranscript(arg) {
+			requests = append(requests, types.NewRequest(arg, types.TypeTranscript, nil))
 		} else if isUrlFile(arg) {
 			requests = append(requests, types.NewRequest(arg, types.TypeUrlFile, nil))
 		} else if isBook(arg) {
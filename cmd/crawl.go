@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/epubgen"
+	"github.com/nikhil1raghav/kindle-send/handler"
+	"github.com/nikhil1raghav/kindle-send/types"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(crawlCmd)
+	crawlCmd.Flags().String("select", "", "CSS selector of the article links on the index page, or of the elements holding them")
+	crawlCmd.Flags().String("match", "", "Regular expression the article links must match, without one only links of the same site are kept")
+	crawlCmd.Flags().Int("limit", epubgen.DefaultCrawlLimit, "Maximum number of articles put in the book")
+	crawlCmd.Flags().String("order", epubgen.CrawlOrderPage, "Order of the articles, one of "+strings.Join(epubgen.CrawlOrderNames(), ", "))
+	crawlCmd.Flags().Bool("send", false, "Send the book to the ereader instead of only saving it")
+	crawlCmd.Flags().IntP("mail-timeout", "m", 120, "Mail timeout in seconds, increase it if sending lot of files")
+	addFetchFlags(crawlCmd)
+	addBookFlags(crawlCmd)
+}
+
+var (
+	helpCrawl = `Collects the article links of an index page or sitemap.xml and downloads
+them as one ebook. Links are deduplicated and capped with --limit, pick them
+with a CSS selector or a regular expression.`
+
+	exampleCrawl = dedent.Dedent(`
+		# Every essay listed on an index page
+		kindle-send crawl --limit 300 "http://paulgraham.com/articles.html"
+
+		# The posts of a sitemap, newest first
+		kindle-send crawl --match "/blog/" --order newest --limit 20 "https://example.com/sitemap.xml"
+
+		# Only the links of the post list, sent to the ereader
+		kindle-send crawl --send --select "ul.posts a.title" "https://example.com/archive"`,
+	)
+)
+
+var crawlCmd = &cobra.Command{
+	Use:     "crawl [INDEX]",
+	Short:   "Download the articles linked from an index page or sitemap as an ebook",
+	Long:    helpCrawl,
+	Example: exampleCrawl,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		_, err := config.Load(configPath)
+		if err != nil {
+			util.Red.Println(err)
+			return
+		}
+		applyFetchFlags(cmd)
+
+		selector, _ := cmd.Flags().GetString("select")
+		match, _ := cmd.Flags().GetString("match")
+		limit, _ := cmd.Flags().GetInt("limit")
+		order, _ := cmd.Flags().GetString("order")
+		if _, err := regexp.Compile(match); err != nil {
+			util.Red.Println("Invalid --match :", err)
+			return
+		}
+		if !slices.Contains(epubgen.CrawlOrderNames(), order) {
+			util.Red.Printf("Unknown order %s, expected one of %s\n", order, strings.Join(epubgen.CrawlOrderNames(), ", "))
+			return
+		}
+
+		requests := withBookOptions(cmd, []types.Request{types.NewRequest(args[0], types.TypeCrawl, nil)})
+		requests[0].Options[types.OptionSelect] = selector
+		requests[0].Options[types.OptionMatch] = match
+		requests[0].Options[types.OptionLimit] = strconv.Itoa(limit)
+		requests[0].Options[types.OptionOrder] = order
+		books := handler.Queue(requests)
+
+		if send, _ := cmd.Flags().GetBool("send"); send {
+			timeout, _ := cmd.Flags().GetInt("mail-timeout")
+			mailOrExit(books, timeout)
+			return
+		}
+		util.CyanBold.Printf("Downloaded %d files :\n", len(books))
+		for idx, req := range books {
+			util.Cyan.Printf("%d. %s\n", idx+1, filepath.Base(req.Path))
+		}
+	},
+}
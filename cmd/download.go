@@ -13,6 +13,8 @@ import (
 
 func init() {
 	rootCmd.AddCommand(downloadCmd)
+	addFetchFlags(downloadCmd)
+	addBookFlags(downloadCmd)
 }
 
 var (
@@ -28,7 +30,10 @@ Supports multiple arguments. Each argument is downloaded as a separate file.`
 		kindle-send download "http://paulgraham.com/alien.html" "http://paulgraham.com/hwh.html"
 
 		# Download webpage and collection of webpages
-		kindle-send download "http://paulgraham.com/alien.html" links.txt`,
+		kindle-send download "http://paulgraham.com/alien.html" links.txt
+
+		# Build a book from the README and docs of a repository
+		kindle-send download "https://github.com/spf13/cobra"`,
 	)
 )
 
@@ -44,8 +49,9 @@ var downloadCmd = &cobra.Command{
 			util.Red.Println(err)
 			return
 		}
+		applyFetchFlags(cmd)
 
-		downloadRequests := classifier.Classify(args)
+		downloadRequests := withBookOptions(cmd, classifier.Classify(args))
 		downloadedRequests := handler.Queue(downloadRequests)
 
 		util.CyanBold.Printf("Downloaded %d files :\n", len(downloadRequests))
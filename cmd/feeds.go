@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/epubgen"
+	"github.com/nikhil1raghav/kindle-send/feeds"
+	"github.com/nikhil1raghav/kindle-send/handler"
+	"github.com/nikhil1raghav/kindle-send/types"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(feedsCmd)
+	feedsCmd.Flags().Int("max-items", feeds.DefaultMaxItems, "Maximum number of new items of a feed put in the digest, older ones are skipped")
+	feedsCmd.Flags().String("state", "", "File tracking the delivered items (default exports/"+feeds.StateFileName+" in the current directory)")
+	feedsCmd.Flags().String("title", "", "Title of the digest (default Feeds and the date)")
+	feedsCmd.Flags().Bool("catch-up", false, "Mark every item of the feeds as delivered without building a digest")
+	feedsCmd.Flags().Bool("send", false, "Send the digest to the ereader instead of only saving it")
+	feedsCmd.Flags().IntP("mail-timeout", "m", 120, "Mail timeout in seconds, increase it if sending lot of files")
+	addFetchFlags(feedsCmd)
+	addBookFlags(feedsCmd)
+}
+
+var (
+	helpFeeds = `Builds a digest of the items published since the last run in the RSS, Atom
+and JSON feeds listed under "feeds" in the config file. Items carrying their full
+text are used as they are, the articles of the others are fetched. Delivered
+items are tracked in a state file next to exports/exported.json.`
+
+	exampleFeeds = dedent.Dedent(`
+		# Save a digest of the new items
+		kindle-send feeds
+
+		# Send it, at most 5 items per feed
+		kindle-send feeds --send --max-items 5
+
+		# Start from now on, without delivering what the feeds hold already
+		kindle-send feeds --catch-up`,
+	)
+)
+
+var feedsCmd = &cobra.Command{
+	Use:     "feeds",
+	Short:   "Build a digest of the new items of subscribed feeds",
+	Long:    helpFeeds,
+	Example: exampleFeeds,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		_, err := config.Load(configPath)
+		if err != nil {
+			util.Red.Println(err)
+			return
+		}
+		applyFetchFlags(cmd)
+		subscriptions := config.Feeds()
+		if len(subscriptions) == 0 {
+			util.Red.Printf("No feeds subscribed, add them under \"feeds\" in %s\n", configPath)
+			return
+		}
+
+		statePath, _ := cmd.Flags().GetString("state")
+		if len(statePath) == 0 {
+			cwd, err := os.Getwd()
+			if err != nil {
+				util.Red.Println("Error getting current directory:", err)
+				return
+			}
+			statePath = filepath.Join(cwd, "exports", feeds.StateFileName)
+		}
+		state, err := feeds.LoadState(statePath)
+		if err != nil {
+			util.Red.Printf("Error reading %s : %s\n", statePath, err)
+			return
+		}
+
+		now := time.Now()
+		maxItems, _ := cmd.Flags().GetInt("max-items")
+		catchUp, _ := cmd.Flags().GetBool("catch-up")
+		if catchUp {
+			// Every item is new to the state, none is left out as too old
+			maxItems = math.MaxInt
+		}
+		batches := feeds.NewItems(subscriptions, state, maxItems, now)
+		var items []epubgen.FeedItem
+		for _, batch := range batches {
+			items = append(items, batch.Items...)
+		}
+
+		if len(items) > 0 && !catchUp {
+			title, _ := cmd.Flags().GetString("title")
+			if len(title) == 0 {
+				title = "Feeds " + now.Format("2 Jan 2006")
+			}
+			options := withBookOptions(cmd, []types.Request{types.NewRequest(title, types.TypeUrl, nil)})[0].Options
+			digest, err := handler.Digest(items, title, options)
+			if err != nil {
+				util.Red.Println("Couldn't build the digest :", err)
+				return
+			}
+			if send, _ := cmd.Flags().GetBool("send"); send {
+				timeout, _ := cmd.Flags().GetInt("mail-timeout")
+				// Items are delivered again next time when the mail fails
+				mailOrExit([]types.Request{digest}, timeout)
+			} else {
+				util.CyanBold.Printf("Saved the digest of %d items to %s\n", len(items), digest.Path)
+			}
+		} else if len(items) == 0 {
+			util.Cyan.Println("No new items since the last run")
+		}
+
+		for _, batch := range batches {
+			state.MarkDelivered(batch.Feed.URL, batch.IDs(), now)
+		}
+		if err := state.Save(); err != nil {
+			util.Red.Printf("Error saving %s : %s\n", statePath, err)
+			return
+		}
+		if catchUp {
+			util.Cyan.Printf("Marked %d items as delivered\n", len(items))
+		}
+	},
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/epubgen"
+	"github.com/nikhil1raghav/kindle-send/types"
+	"github.com/spf13/cobra"
+)
+
+// addFetchFlags registers the flags controlling parallel and multi-page
+// fetching and how discussion threads are rendered
+func addFetchFlags(c *cobra.Command) {
+	c.Flags().Int("concurrency", epubgen.DefaultFetchLimit, "Maximum number of webpages fetched in parallel")
+	c.Flags().Int("per-host", epubgen.DefaultPerHostLimit, "Maximum number of webpages fetched in parallel from the same website")
+	c.Flags().Int("max-pages", epubgen.DefaultPageLimit, "Maximum number of pages followed for articles split over several pages, 1 to only fetch the first")
+	c.Flags().Int("comment-depth", epubgen.DefaultCommentDepth, "Nesting depth below which replies of Hacker News, Reddit and Mastodon threads are collapsed, 0 for no limit")
+	c.Flags().Int("comment-min-score", 0, "Collapse Reddit comments scored lower than this, 0 keeps all")
+	c.Flags().Bool("with-article", false, "Put the article linked by a Hacker News or Reddit thread before its comments")
+	c.Flags().Bool("author-only", false, "Only keep the posts of the author of a Mastodon thread, without the replies")
+}
+
+// applyFetchFlags passes the fetching limits given on the command line to epubgen
+func applyFetchFlags(c *cobra.Command) {
+	concurrency, _ := c.Flags().GetInt("concurrency")
+	perHost, _ := c.Flags().GetInt("per-host")
+	epubgen.SetFetchLimits(concurrency, perHost)
+	maxPages, _ := c.Flags().GetInt("max-pages")
+	epubgen.SetPageLimit(maxPages)
+	depth, _ := c.Flags().GetInt("comment-depth")
+	minScore, _ := c.Flags().GetInt("comment-min-score")
+	withArticle, _ := c.Flags().GetBool("with-article")
+	authorOnly, _ := c.Flags().GetBool("author-only")
+	epubgen.SetDiscussionOptions(epubgen.DiscussionOptions{MaxDepth: depth, MinScore: minScore, WithArticle: withArticle, AuthorOnly: authorOnly})
+}
+
+// addBookFlags registers the flags controlling how ebooks are built
+func addBookFlags(c *cobra.Command) {
+	c.Flags().String("profile", "", "Device profile used to build ebooks, one of "+strings.Join(config.ProfileNames(), ", ")+" or a profile from the config file")
+	c.Flags().String("cover", "", "Image file used as cover instead of the generated one")
+	c.Flags().Int("toc-depth", 0, "Headings of articles listed in the table of contents, 1 for h2, 2 for h2 and h3")
+	c.Flags().String("format", epubgen.FormatEPUB, "Output format, one of "+strings.Join(epubgen.FormatNames(), ", ")+" ("+epubgen.FormatKEPUB+" is for Kobo readers)")
+	c.Flags().StringSlice("tags", nil, "Tags written in the front matter of Markdown notes, eg. reading,kindle")
+	c.Flags().String("page-size", epubgen.DefaultPageSize, "Page size of PDF books, one of "+strings.Join(epubgen.PageSizeNames(), ", ")+" or WIDTHxHEIGHT in millimetres")
+	c.Flags().Float64("font-size", epubgen.DefaultFontSize, "Font size of PDF books in points")
+	c.Flags().String("endnotes", "", "Turn external links into numbered endnotes at the end of every "+epubgen.EndnotesArticle+" or of the "+epubgen.EndnotesBook)
+	c.Flags().String("theme", "", "Reading theme, one of "+strings.Join(epubgen.ThemeNames(), ", ")+" (default is "+epubgen.DefaultTheme+" or the theme from the config file)")
+}
+
+// withBookOptions sets the ebook options given on the command line on every request
+func withBookOptions(c *cobra.Command, requests []types.Request) []types.Request {
+	profile, _ := c.Flags().GetString("profile")
+	cover, _ := c.Flags().GetString("cover")
+	tocDepth, _ := c.Flags().GetInt("toc-depth")
+	theme, _ := c.Flags().GetString("theme")
+	endnotes, _ := c.Flags().GetString("endnotes")
+	format, _ := c.Flags().GetString("format")
+	tags, _ := c.Flags().GetStringSlice("tags")
+	pageSize, _ := c.Flags().GetString("page-size")
+	fontSize, _ := c.Flags().GetFloat64("font-size")
+	for i := range requests {
+		if requests[i].Options == nil {
+			requests[i].Options = make(map[string]string)
+		}
+		requests[i].Options[types.OptionProfile] = profile
+		requests[i].Options[types.OptionCover] = cover
+		requests[i].Options[types.OptionTOCDepth] = strconv.Itoa(tocDepth)
+		requests[i].Options[types.OptionTheme] = theme
+		requests[i].Options[types.OptionEndnotes] = endnotes
+		requests[i].Options[types.OptionFormat] = format
+		requests[i].Options[types.OptionTags] = strings.Join(tags, ",")
+		requests[i].Options[types.OptionPageSize] = pageSize
+		requests[i].Options[types.OptionFontSize] = strconv.FormatFloat(fontSize, 'g', -1, 64)
+	}
+	return requests
+}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/lithammer/dedent"
 	"github.com/nikhil1raghav/kindle-send/classifier"
 	"github.com/nikhil1raghav/kindle-send/config"
 	"github.com/nikhil1raghav/kindle-send/handler"
+	"github.com/nikhil1raghav/kindle-send/types"
 	"github.com/nikhil1raghav/kindle-send/util"
 	"github.com/spf13/cobra"
 )
@@ -33,6 +36,8 @@ kindle-send auto detects if argument is a link, collection of links or an ebook.
 
 func init() {
 	sendCmd.PersistentFlags().IntP("mail-timeout", "m", 120, "Mail timeout in seconds, increase it if sending lot of files")
+	addFetchFlags(sendCmd)
+	addBookFlags(sendCmd)
 }
 
 var sendCmd = &cobra.Command{
@@ -47,8 +52,9 @@ var sendCmd = &cobra.Command{
 			util.Red.Println(err)
 			return
 		}
+		applyFetchFlags(cmd)
 
-		downloadRequests := classifier.Classify(args)
+		downloadRequests := withBookOptions(cmd, classifier.Classify(args))
 		downloadedRequests := handler.Queue(downloadRequests)
 
 		timeout, err := cmd.Flags().GetInt("mail-timeout")
@@ -56,7 +62,16 @@ var sendCmd = &cobra.Command{
 			timeout = 0
 		}
 
-		handler.Mail(downloadedRequests, timeout)
+		mailOrExit(downloadedRequests, timeout)
 
 	},
 }
+
+// mailOrExit mails the files of requests to the ereader, exits with an
+// error when it couldn't
+func mailOrExit(requests []types.Request, timeout int) {
+	if err := handler.Mail(requests, timeout); err != nil {
+		util.Red.Println("Error sending mail :", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/cookies"
+	"github.com/nikhil1raghav/kindle-send/epubgen"
+	"github.com/nikhil1raghav/kindle-send/handler"
+	"github.com/nikhil1raghav/kindle-send/types"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(substackCmd)
+	substackCmd.Flags().String("since", "", "Only keep posts published on or after this date, YYYY-MM-DD")
+	substackCmd.Flags().String("until", "", "Only keep posts published on or before this date, YYYY-MM-DD")
+	substackCmd.Flags().Bool("per-year", false, "Make one book per year instead of one for the whole archive")
+	substackCmd.Flags().StringP("cookies", "k", "", "Cookies file giving access to paid posts (default cookies.json in the current directory)")
+	substackCmd.Flags().Bool("send", false, "Send the books to the ereader instead of only saving them")
+	substackCmd.Flags().IntP("mail-timeout", "m", 120, "Mail timeout in seconds, increase it if sending lot of files")
+	addFetchFlags(substackCmd)
+	addBookFlags(substackCmd)
+}
+
+var (
+	helpSubstack = `Downloads the archive of a Substack publication as an ebook, posts in the
+order they were published. Paid posts are read with the cookies of a subscriber,
+loaded from cookies.json in the current directory or the file given with --cookies.`
+
+	exampleSubstack = dedent.Dedent(`
+		# Download the whole archive of a publication
+		kindle-send substack "https://example.substack.com"
+
+		# Send the posts of 2023, publications on custom domains work too
+		kindle-send substack --send --since 2023-01-01 --until 2023-12-31 "https://www.example.com"
+
+		# One book per year, with the cookies of a paid subscription
+		kindle-send substack --per-year --cookies cookies.txt "https://example.substack.com"`,
+	)
+)
+
+var substackCmd = &cobra.Command{
+	Use:     "substack [PUBLICATION]",
+	Short:   "Download the archive of a Substack publication as ebooks",
+	Long:    helpSubstack,
+	Example: exampleSubstack,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		_, err := config.Load(configPath)
+		if err != nil {
+			util.Red.Println(err)
+			return
+		}
+		applyFetchFlags(cmd)
+
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+		for _, date := range []string{since, until} {
+			if _, err := time.Parse(time.DateOnly, date); len(date) > 0 && err != nil {
+				util.Red.Printf("%s is not a date like 2023-01-31\n", date)
+				return
+			}
+		}
+		cookiesFile, _ := cmd.Flags().GetString("cookies")
+		if err := useCookies(cookiesFile); err != nil {
+			util.Red.Println("Error loading cookies:", err)
+			return
+		}
+
+		requests := withBookOptions(cmd, []types.Request{types.NewRequest(args[0], types.TypeSubstack, nil)})
+		perYear, _ := cmd.Flags().GetBool("per-year")
+		requests[0].Options[types.OptionSince] = since
+		requests[0].Options[types.OptionUntil] = until
+		requests[0].Options[types.OptionPerYear] = strconv.FormatBool(perYear)
+		books := handler.Queue(requests)
+
+		if send, _ := cmd.Flags().GetBool("send"); send {
+			timeout, _ := cmd.Flags().GetInt("mail-timeout")
+			mailOrExit(books, timeout)
+			return
+		}
+		util.CyanBold.Printf("Downloaded %d files :\n", len(books))
+		for idx, req := range books {
+			util.Cyan.Printf("%d. %s\n", idx+1, filepath.Base(req.Path))
+		}
+	},
+}
+
+// useCookies makes pages be fetched with the cookies of file, or of
+// cookies.json in the current directory if there is one
+func useCookies(file string) error {
+	if len(file) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		file = filepath.Join(cwd, "cookies.json")
+		if _, err := os.Stat(file); err != nil {
+			return nil
+		}
+	}
+	client, err := cookies.LoadCookies(file)
+	if err != nil {
+		return fmt.Errorf("%s : %w", file, err)
+	}
+	epubgen.SetHTTPClient(client)
+	util.Green.Println("Loaded cookies from", file)
+	return nil
+}
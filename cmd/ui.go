@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/nikhil1raghav/kindle-send/config"
 	"github.com/nikhil1raghav/kindle-send/cookies"
 	"github.com/nikhil1raghav/kindle-send/epubgen"
 	"github.com/nikhil1raghav/kindle-send/ui"
@@ -15,6 +16,7 @@ func init() {
 	rootCmd.AddCommand(uiCmd)
 	uiCmd.Flags().IntP("port", "p", 8080, "Port to run the web server on")
 	uiCmd.Flags().StringP("cookies", "k", "", "Path to cookies.txt file (Netscape format)")
+	addFetchFlags(uiCmd)
 }
 
 var uiCmd = &cobra.Command{
@@ -27,6 +29,16 @@ To access paywalled content, export your browser cookies to a cookies.txt file
 	Run: func(cmd *cobra.Command, args []string) {
 		port, _ := cmd.Flags().GetInt("port")
 		cookiesFile, _ := cmd.Flags().GetString("cookies")
+		applyFetchFlags(cmd)
+
+		// Device profiles can be defined in the config, but the UI works without one
+		configPath, _ := cmd.Flags().GetString("config")
+		if _, err := os.Stat(configPath); err == nil {
+			if _, err := config.Load(configPath); err != nil {
+				util.Red.Println(err)
+				return
+			}
+		}
 
 		// Get the current working directory
 		cwd, err := os.Getwd()
@@ -19,6 +19,14 @@ type config struct {
 	Password  string `json:"password"`
 	Server    string `json:"server"`
 	Port      int    `json:"port"`
+	// Default device profile and user defined profiles
+	Profile  string             `json:"profile,omitempty"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Default reading theme and a stylesheet added after it
+	Theme      string `json:"theme,omitempty"`
+	Stylesheet string `json:"stylesheet,omitempty"`
+	// Feeds the feeds command builds digests of
+	Feeds []Feed `json:"feeds,omitempty"`
 }
 
 const DefaultTimeout = 120
@@ -0,0 +1,17 @@
+package config
+
+// Feed is an RSS, Atom or JSON feed subscribed to in the config file
+type Feed struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"` // Replaces the title of the feed in books
+	// Fetch the article of every item even when the feed has its full text
+	FetchArticles bool `json:"fetch_articles,omitempty"`
+}
+
+// Feeds returns the feeds subscribed to in the loaded config
+func Feeds() []Feed {
+	if instance == nil {
+		return nil
+	}
+	return instance.Feeds
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profile describes how books are tailored to a reading device
+type Profile struct {
+	Width       int    `json:"width"`        // Max image width in pixels
+	Height      int    `json:"height"`       // Max image height in pixels
+	Grayscale   bool   `json:"grayscale"`    // Convert images to grayscale
+	Quality     int    `json:"quality"`      // JPEG quality (1-100)
+	MaxImageKB  int    `json:"max_image_kb"` // Max size of a single image, 0 for no limit
+	ImageFormat string `json:"image_format"` // auto, jpeg or png
+
+	// E-ink stage, enabled when GrayLevels is set
+	GrayLevels int  `json:"gray_levels"` // Shades of gray the screen shows, eg. 16
+	Dither     bool `json:"dither"`      // Floyd–Steinberg dithering when reducing gray levels
+	AutoLevels bool `json:"auto_levels"` // Stretch contrast so the darkest pixel is black and the brightest white
+	Sharpen    bool `json:"sharpen"`     // Sharpen after resizing
+}
+
+const DefaultProfile = "kindle"
+
+// Image formats a profile can prefer
+const (
+	ImageFormatAuto = "auto"
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+)
+
+// Profiles available without any configuration, entries in the config file
+// with the same name replace them
+var builtinProfiles = map[string]Profile{
+	"kindle":            {Width: 800, Height: 1200, Quality: 75, ImageFormat: ImageFormatAuto},
+	"paperwhite":        {Width: 1236, Height: 1648, Grayscale: true, Quality: 70, MaxImageKB: 300, ImageFormat: ImageFormatAuto, GrayLevels: 16, Dither: true, AutoLevels: true},
+	"scribe":            {Width: 1860, Height: 2480, Grayscale: true, Quality: 75, MaxImageKB: 500, ImageFormat: ImageFormatAuto, GrayLevels: 16, Dither: true, AutoLevels: true, Sharpen: true},
+	"kobo-libra-colour": {Width: 1264, Height: 1680, Quality: 75, MaxImageKB: 400, ImageFormat: ImageFormatAuto},
+	"phone":             {Width: 1080, Height: 2400, Quality: 80, MaxImageKB: 400, ImageFormat: ImageFormatAuto},
+}
+
+// withDefaults fills the settings left empty in a user defined profile
+func (p Profile) withDefaults() Profile {
+	def := builtinProfiles[DefaultProfile]
+	if p.Width <= 0 {
+		p.Width = def.Width
+	}
+	if p.Height <= 0 {
+		p.Height = def.Height
+	}
+	if p.Quality <= 0 || p.Quality > 100 {
+		p.Quality = def.Quality
+	}
+	if len(p.ImageFormat) == 0 {
+		p.ImageFormat = ImageFormatAuto
+	}
+	return p
+}
+
+// GetProfile returns the device profile with the given name, looking in the
+// loaded config before the built-in profiles. An empty name selects the
+// profile set as default in the config, or the kindle profile.
+func GetProfile(name string) (Profile, error) {
+	if len(name) == 0 {
+		name = DefaultProfile
+		if instance != nil && len(instance.Profile) > 0 {
+			name = instance.Profile
+		}
+	}
+	if instance != nil {
+		if p, ok := instance.Profiles[name]; ok {
+			return p.withDefaults(), nil
+		}
+	}
+	if p, ok := builtinProfiles[name]; ok {
+		return p, nil
+	}
+	return Profile{}, fmt.Errorf("unknown device profile %q, available profiles are %v", name, ProfileNames())
+}
+
+// ProfileNames returns the names of all available profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(builtinProfiles))
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	if instance != nil {
+		for name := range instance.Profiles {
+			if _, ok := builtinProfiles[name]; !ok {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,104 @@
+package epubgen
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+)
+
+// Adapter fetches and extracts the articles of a site readability handles
+// badly. It can get the page any way it likes, from an API for example.
+type Adapter interface {
+	// Fetch returns the article at pageURL. Returning ErrSkipAdapter leaves
+	// the page to readability, any other error is logged before falling back.
+	Fetch(pageURL *url.URL) (Article, error)
+}
+
+// AdapterFunc lets an ordinary function be used as an Adapter
+type AdapterFunc func(pageURL *url.URL) (Article, error)
+
+func (f AdapterFunc) Fetch(pageURL *url.URL) (Article, error) {
+	return f(pageURL)
+}
+
+// ErrSkipAdapter is returned by adapters for pages of their site they don't
+// handle, like the home page of a site they extract posts from
+var ErrSkipAdapter = errors.New("page left to readability")
+
+// siteAdapter is an adapter and the host pattern it was registered for
+type siteAdapter struct {
+	pattern string
+	adapter Adapter
+}
+
+var (
+	adaptersMu sync.RWMutex
+	adapters   []siteAdapter
+)
+
+// RegisterAdapter makes adapter handle the pages of the hosts matching
+// pattern. A host like "news.ycombinator.com" matches that host and its
+// subdomains, "*.substack.com" only the subdomains. "*" matches every host,
+// for software running on any site whose pages are recognised by their path;
+// those adapters are only used when no other pattern matches. When several
+// patterns match, the adapter registered last is used.
+func RegisterAdapter(pattern string, adapter Adapter) {
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+	adapters = append(adapters, siteAdapter{pattern: strings.ToLower(pattern), adapter: adapter})
+}
+
+// hostMatches reports whether host matches a pattern of RegisterAdapter
+func hostMatches(pattern string, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// adapterFor returns the adapter handling pageURL and the pattern it was
+// registered for, nil if readability extracts the page
+func adapterFor(pageURL *url.URL) (Adapter, string) {
+	host := strings.ToLower(pageURL.Hostname())
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	for _, fallback := range []bool{false, true} {
+		for i := len(adapters) - 1; i >= 0; i-- {
+			if (adapters[i].pattern == "*") == fallback && hostMatches(adapters[i].pattern, host) {
+				return adapters[i].adapter, adapters[i].pattern
+			}
+		}
+	}
+	return nil, ""
+}
+
+// NewArticle builds an article from html extracted by an adapter, parsed so
+// its images get embedded like those of readable pages
+func NewArticle(title string, byline string, content string, source string) Article {
+	var node *html.Node
+	var text string
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + content + "</body>"))
+	if err == nil {
+		node = doc.Find("body").Get(0)
+		text = doc.Text()
+	}
+	return Article{
+		Article: readability.Article{
+			Title:       title,
+			Byline:      byline,
+			Content:     content,
+			TextContent: text,
+			Length:      len(text),
+			Node:        node,
+		},
+		Source: source,
+	}
+}
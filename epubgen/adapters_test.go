@@ -0,0 +1,99 @@
+package epubgen
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostMatches(t *testing.T) {
+	for _, c := range []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"twitter.com", "twitter.com", true},
+		{"twitter.com", "mobile.twitter.com", true},
+		{"twitter.com", "nottwitter.com", false},
+		{"*.substack.com", "astral.substack.com", true},
+		{"*.substack.com", "substack.com", false},
+		{"*", "example.com", true},
+	} {
+		if got := hostMatches(c.pattern, c.host); got != c.want {
+			t.Errorf("hostMatches(%s, %s) = %v, want %v", c.pattern, c.host, got, c.want)
+		}
+	}
+}
+
+func TestAdapterForChecksAnyHostLast(t *testing.T) {
+	saved := adapters
+	defer func() { adapters = saved }()
+	adapters = nil
+	skip := AdapterFunc(func(*url.URL) (Article, error) { return Article{}, ErrSkipAdapter })
+	RegisterAdapter("example.com", skip)
+	RegisterAdapter("*", skip)
+	for host, want := range map[string]string{"www.example.com": "example.com", "example.org": "*"} {
+		if _, pattern := adapterFor(&url.URL{Host: host}); pattern != want {
+			t.Errorf("adapterFor(%s) = %s, want %s", host, pattern, want)
+		}
+	}
+}
+
+func TestFetchReadableUsesAdapter(t *testing.T) {
+	text := strings.Repeat("Readable text with enough words, and commas, to be kept. ", 6)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Page</title></head><body><article><p>From readability. %s</p></article></body></html>`, text)
+	}))
+	defer server.Close()
+
+	saved := adapters
+	defer func() { adapters = saved }()
+	RegisterAdapter("127.0.0.1", AdapterFunc(func(pageURL *url.URL) (Article, error) {
+		switch pageURL.Path {
+		case "/skip":
+			return Article{}, ErrSkipAdapter
+		case "/broken":
+			return Article{}, errors.New("broken")
+		}
+		return NewArticle("Adapted", "Someone", "<p>From the adapter</p>", ""), nil
+	}))
+
+	article, err := fetchReadable(server.URL + "/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Adapted" || article.Source != server.URL+"/post" || article.Node == nil {
+		t.Errorf("expected the adapted article, got %+v", article)
+	}
+	for _, path := range []string{"/skip", "/broken"} {
+		article, err := fetchReadable(server.URL + path)
+		if err != nil || !strings.Contains(article.Content, "From readability") {
+			t.Errorf("%s : expected readability to take over, got %v %s", path, err, article.Content)
+		}
+	}
+}
+
+func TestTweetArticle(t *testing.T) {
+	embed := tweetEmbed{
+		AuthorName: "Go",
+		AuthorURL:  "https://twitter.com/golang",
+		HTML: `<blockquote class="twitter-tweet"><p lang="en" dir="ltr">Go 1.22 is released! <a href="https://t.co/abc">https://t.co/abc</a></p>&mdash; Go (@golang) ` +
+			`<a href="https://twitter.com/golang/status/1?ref_src=twsrc%5Etfw">February 6, 2024</a></blockquote>`,
+	}
+	article, err := tweetArticle(embed, "https://x.com/golang/status/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Go on X: Go 1.22 is released! https://t.co/abc" {
+		t.Errorf("unexpected title %q", article.Title)
+	}
+	if article.Published.Format("2006-01-02") != "2024-02-06" {
+		t.Errorf("unexpected date %s", article.Published)
+	}
+	if !strings.Contains(article.Content, `<a href="https://t.co/abc">`) || !strings.Contains(article.Content, "Go (@golang)") {
+		t.Errorf("unexpected content %s", article.Content)
+	}
+}
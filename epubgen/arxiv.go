@@ -0,0 +1,229 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+func init() {
+	RegisterAdapter("arxiv.org", AdapterFunc(fetchArxiv))
+}
+
+var arxivSite = "https://arxiv.org"
+
+// Abstract, PDF and HTML pages of a paper, with new ids like 1706.03762 or
+// old ones like hep-th/9901001, and an optional version
+var arxivPath = regexp.MustCompile(`^/(?:abs|pdf|html)/((?:[a-z-]+(?:\.[A-Z]{2})?/\d{7})|\d{4}\.\d{4,5})(v\d+)?(?:\.pdf)?/?$`)
+
+// arxivPaper is the metadata of a paper from its abstract page
+type arxivPaper struct {
+	Title     string
+	Authors   []string
+	Abstract  string
+	Published time.Time
+	HTMLURL   string // Empty when the paper only comes as a PDF
+	PDFURL    string
+}
+
+var (
+	arxivMu     sync.Mutex
+	arxivPapers = make(map[string]arxivPaper)
+)
+
+// arxivID returns the id of the paper at pageURL with its version if given
+func arxivID(pageURL *url.URL) (string, bool) {
+	match := arxivPath.FindStringSubmatch(pageURL.Path)
+	if match == nil {
+		return "", false
+	}
+	return match[1] + match[2], true
+}
+
+// arxivLookup fetches the metadata of a paper, the queue and the adapter
+// both need it so it is kept for the run
+func arxivLookup(id string) (arxivPaper, error) {
+	arxivMu.Lock()
+	paper, ok := arxivPapers[id]
+	arxivMu.Unlock()
+	if ok {
+		return paper, nil
+	}
+	absURL := arxivSite + "/abs/" + id
+	data, status, err := fetchPage(absURL)
+	if err != nil {
+		return arxivPaper{}, err
+	}
+	if status != http.StatusOK {
+		return arxivPaper{}, errors.New("arxiv: " + http.StatusText(status))
+	}
+	base, _ := url.Parse(absURL)
+	paper, err = arxivAbstract(data, base)
+	if err != nil {
+		return arxivPaper{}, err
+	}
+	arxivMu.Lock()
+	arxivPapers[id] = paper
+	arxivMu.Unlock()
+	return paper, nil
+}
+
+// arxivAbstract reads the metadata of a paper from the citation tags of its
+// abstract page
+func arxivAbstract(page []byte, base *url.URL) (arxivPaper, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return arxivPaper{}, err
+	}
+	meta := func(name string) string {
+		return strings.TrimSpace(doc.Find(`meta[name="`+name+`"]`).First().AttrOr("content", ""))
+	}
+	paper := arxivPaper{Title: meta("citation_title"), Abstract: meta("citation_abstract"), PDFURL: meta("citation_pdf_url")}
+	if len(paper.Title) == 0 {
+		return arxivPaper{}, errors.New("no paper on the arxiv page")
+	}
+	// Authors are written "Last, First"
+	doc.Find(`meta[name="citation_author"]`).Each(func(_ int, m *goquery.Selection) {
+		name := strings.TrimSpace(m.AttrOr("content", ""))
+		if last, first, ok := strings.Cut(name, ","); ok {
+			name = strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+		}
+		paper.Authors = append(paper.Authors, name)
+	})
+	for _, name := range []string{"citation_date", "citation_online_date"} {
+		if date, err := time.Parse("2006/01/02", meta(name)); err == nil {
+			paper.Published = date
+			break
+		}
+	}
+	if len(paper.Abstract) == 0 {
+		abstract := doc.Find("blockquote.abstract").Clone()
+		abstract.Find(".descriptor").Remove()
+		paper.Abstract = strings.TrimSpace(abstract.Text())
+	}
+	if href, ok := doc.Find("#latexml-download-link").Attr("href"); ok {
+		if link, err := base.Parse(href); err == nil {
+			paper.HTMLURL = link.String()
+		}
+	}
+	return paper, nil
+}
+
+// arxivPDFOnly returns the PDF of a paper at pageURL that has no HTML version
+func arxivPDFOnly(pageURL *url.URL) (PassThrough, bool) {
+	if !hostMatches("arxiv.org", strings.ToLower(pageURL.Hostname())) {
+		return PassThrough{}, false
+	}
+	id, ok := arxivID(pageURL)
+	if !ok {
+		return PassThrough{}, false
+	}
+	paper, err := arxivLookup(id)
+	if err != nil || len(paper.HTMLURL) > 0 || len(paper.PDFURL) == 0 {
+		return PassThrough{}, false
+	}
+	return PassThrough{URL: paper.PDFURL, Name: pdfName(paper.Title)}, true
+}
+
+// fetchArxiv extracts the HTML version of a paper from any of its pages,
+// papers without one get their abstract and a link to the PDF
+func fetchArxiv(pageURL *url.URL) (Article, error) {
+	id, ok := arxivID(pageURL)
+	if !ok {
+		return Article{}, ErrSkipAdapter
+	}
+	paper, err := arxivLookup(id)
+	if err != nil {
+		return Article{}, err
+	}
+	if len(paper.HTMLURL) == 0 {
+		util.Magenta.Printf("%s has no HTML version, only adding its abstract\n", paper.Title)
+		return arxivAbstractArticle(paper, pageURL.String()), nil
+	}
+	data, status, err := fetchPage(paper.HTMLURL)
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("arxiv html: " + http.StatusText(status))
+	}
+	// Figures are relative to the directory of the paper
+	base, err := url.Parse(strings.TrimSuffix(paper.HTMLURL, "/") + "/")
+	if err != nil {
+		return Article{}, err
+	}
+	return arxivArticle(paper, data, base, pageURL.String())
+}
+
+// arxivArticle cleans up the HTML version of a paper. Sections are unwrapped
+// so the paper splits at their headings, and citations keep linking to the
+// bibliography which ends up as the endnotes of the paper. MathML is kept.
+func arxivArticle(paper arxivPaper, page []byte, base *url.URL, source string) (Article, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return Article{}, err
+	}
+	document := doc.Find("article.ltx_document").First()
+	if document.Length() == 0 {
+		return Article{}, errors.New("no paper in the arxiv html")
+	}
+	// The header of the book has the title and authors
+	document.Find("script, style, nav, button, .ltx_TOC, .ltx_page_header, .ltx_page_footer, .ltx_title_document, .ltx_authors, .ltx_dates, .package-alerts").Remove()
+	document.Find("section").Each(func(_ int, section *goquery.Selection) {
+		if id, ok := section.Attr("id"); ok {
+			heading := section.ChildrenFiltered("h1, h2, h3, h4, h5, h6").First()
+			if _, has := heading.Attr("id"); heading.Length() > 0 && !has {
+				heading.SetAttr("id", id)
+			} else {
+				section.PrependHtml(`<a id="` + htmlutil.EscapeString(id) + `"></a>`)
+			}
+		}
+		section.ReplaceWithSelection(section.Contents())
+	})
+	document.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href := a.AttrOr("href", "")
+		if link, err := base.Parse(href); err == nil && !strings.HasPrefix(href, "#") {
+			a.SetAttr("href", link.String())
+		}
+	})
+	document.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		if link, err := base.Parse(img.AttrOr("src", "")); err == nil {
+			img.SetAttr("src", link.String())
+		}
+	})
+
+	content, err := document.Html()
+	if err != nil {
+		return Article{}, err
+	}
+	return paperArticle(paper, content, source), nil
+}
+
+// arxivAbstractArticle is the article of a paper only published as a PDF
+func arxivAbstractArticle(paper arxivPaper, source string) Article {
+	var content strings.Builder
+	content.WriteString("<h2>Abstract</h2><p>" + htmlutil.EscapeString(paper.Abstract) + "</p>")
+	if len(paper.PDFURL) > 0 {
+		link := htmlutil.EscapeString(paper.PDFURL)
+		content.WriteString(fmt.Sprintf(`<p>The full text is only available as a PDF: <a href="%s">%s</a></p>`, link, link))
+	}
+	return paperArticle(paper, content.String(), source)
+}
+
+func paperArticle(paper arxivPaper, content string, source string) Article {
+	article := NewArticle(paper.Title, strings.Join(paper.Authors, ", "), content, source)
+	article.SiteName = "arXiv"
+	article.Excerpt = paper.Abstract
+	article.Published = paper.Published
+	return article
+}
@@ -0,0 +1,120 @@
+package epubgen
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const arxivAbsPage = `<html><head>
+<meta name="citation_title" content="A Paper"/>
+<meta name="citation_author" content="Lovelace, Ada"/>
+<meta name="citation_author" content="Babbage, Charles"/>
+<meta name="citation_date" content="2024/03/05"/>
+<meta name="citation_pdf_url" content="https://arxiv.org/pdf/%[1]s"/>
+<meta name="citation_abstract" content="We compute things."/>
+</head><body>%[2]s</body></html>`
+
+func TestArxivID(t *testing.T) {
+	for path, want := range map[string]string{
+		"/abs/1706.03762":        "1706.03762",
+		"/pdf/1706.03762v5.pdf":  "1706.03762v5",
+		"/html/2401.00001v2/":    "2401.00001v2",
+		"/abs/hep-th/9901001v1":  "hep-th/9901001v1",
+		"/abs/math.GT/0309136":   "math.GT/0309136",
+		"/list/cs.LG/recent":     "",
+		"/abs/1706.03762/extras": "",
+	} {
+		id, _ := arxivID(&url.URL{Path: path})
+		if id != want {
+			t.Errorf("arxivID(%s) = %q, want %q", path, id, want)
+		}
+	}
+}
+
+func TestArxivArticle(t *testing.T) {
+	base, _ := url.Parse("https://arxiv.org/abs/2401.00001")
+	paper, err := arxivAbstract([]byte(fmt.Sprintf(arxivAbsPage, "2401.00001",
+		`<a href="https://arxiv.org/html/2401.00001v1" id="latexml-download-link">HTML</a>`)), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(paper.Authors, ", ") != "Ada Lovelace, Charles Babbage" || paper.HTMLURL != "https://arxiv.org/html/2401.00001v1" ||
+		paper.Published.Format("2006-01-02") != "2024-03-05" {
+		t.Errorf("unexpected paper %+v", paper)
+	}
+
+	page := `<html><body><nav class="ltx_page_navbar">Contents</nav><article class="ltx_document">
+<h1 class="ltx_title ltx_title_document">A Paper</h1><div class="ltx_authors">Ada Lovelace</div>
+<section class="ltx_section" id="S1"><h2 class="ltx_title">1 Introduction</h2>
+<p>As shown <cite class="ltx_cite"><a href="#bib.bib1" class="ltx_ref">1</a></cite>, <math alttext="x^{2}" display="inline"><semantics><msup><mi>x</mi><mn>2</mn></msup></semantics></math> grows.</p>
+<figure class="ltx_figure" id="S1.F1"><img src="x1.png" class="ltx_graphics"/><figcaption class="ltx_caption">Figure 1: Growth</figcaption></figure>
+</section>
+<section class="ltx_bibliography" id="bib"><h2 class="ltx_title">References</h2>
+<ul class="ltx_biblist"><li id="bib.bib1" class="ltx_bibitem">Someone, 2020.</li></ul></section>
+</article></body></html>`
+	htmlBase, _ := url.Parse(paper.HTMLURL + "/")
+	article, err := arxivArticle(paper, []byte(page), htmlBase, base.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Byline != "Ada Lovelace, Charles Babbage" || article.Excerpt != "We compute things." {
+		t.Errorf("unexpected metadata %q %q", article.Byline, article.Excerpt)
+	}
+	for _, want := range []string{
+		`<h2 class="ltx_title" id="S1">1 Introduction</h2>`,
+		`<math alttext="x^{2}" display="inline"><semantics><msup><mi>x</mi><mn>2</mn></msup></semantics></math>`,
+		`<img src="https://arxiv.org/html/2401.00001v1/x1.png"`,
+		`<figcaption class="ltx_caption">Figure 1: Growth</figcaption>`,
+		`<a href="#bib.bib1" class="ltx_ref">1</a>`,
+		`<li id="bib.bib1" class="ltx_bibitem">`,
+	} {
+		if !strings.Contains(article.Content, want) {
+			t.Errorf("expected %q in %s", want, article.Content)
+		}
+	}
+	for _, junk := range []string{"Contents", "ltx_title_document", "<section"} {
+		if strings.Contains(article.Content, junk) {
+			t.Errorf("expected %q to be removed from %s", junk, article.Content)
+		}
+	}
+	if _, chapters := splitAtHeadings(article.Content, 1); len(chapters) != 2 || chapters[1].Title != "References" {
+		t.Errorf("expected the paper to split into its sections and bibliography, got %+v", chapters)
+	}
+}
+
+func TestSplitPassThrough(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/abs/1111.11111":
+			fmt.Fprintf(w, arxivAbsPage, "1111.11111", "")
+		case "/abs/2222.22222":
+			fmt.Fprintf(w, arxivAbsPage, "2222.22222", `<a href="/html/2222.22222v1" id="latexml-download-link">HTML</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	saved := arxivSite
+	defer func() {
+		arxivSite = saved
+		arxivPapers = make(map[string]arxivPaper)
+	}()
+	arxivSite = server.URL
+
+	pages, files := SplitPassThrough([]string{
+		"https://arxiv.org/abs/1111.11111",
+		"https://arxiv.org/abs/2222.22222",
+		"https://example.com/papers/Some%20Report.PDF",
+		"https://example.com/post",
+	})
+	if strings.Join(pages, " ") != "https://arxiv.org/abs/2222.22222 https://example.com/post" {
+		t.Errorf("unexpected pages %v", pages)
+	}
+	if len(files) != 2 || files[0] != (PassThrough{URL: "https://arxiv.org/pdf/1111.11111", Name: "a-paper.pdf"}) || files[1].Name != "some-report.pdf" {
+		t.Errorf("unexpected files %+v", files)
+	}
+}
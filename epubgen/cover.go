@@ -0,0 +1,242 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Cover layout, as fractions of the cover width
+const (
+	coverMargin        = 0.08
+	coverTitleSize     = 0.10 // Largest title font, shrunk until it fits
+	coverTitleMinSize  = 0.05
+	coverTitleMaxLines = 5
+	coverTextSize      = 0.035
+	coverSmallSize     = 0.028
+	coverMaxDomains    = 6
+)
+
+var (
+	coverInk   = color.Gray{Y: 0x10}
+	coverMuted = color.Gray{Y: 0x55}
+)
+
+// coverInfo is what gets printed on a generated cover
+type coverInfo struct {
+	Title    string
+	Date     time.Time
+	Articles int
+	Domains  []string
+	Lead     image.Image // Optional lead image of the first article
+}
+
+// newCoverInfo collects the cover details of a book
+func newCoverInfo(title string, articles []Article) coverInfo {
+	info := coverInfo{
+		Title:    title,
+		Date:     time.Now(),
+		Articles: len(articles),
+	}
+	seen := make(map[string]bool)
+	for _, article := range articles {
+		u, err := url.Parse(article.Source)
+		if err != nil || len(u.Hostname()) == 0 {
+			continue
+		}
+		domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		if !seen[domain] {
+			seen[domain] = true
+			info.Domains = append(info.Domains, domain)
+		}
+	}
+	for _, article := range articles {
+		if len(article.Image) == 0 {
+			continue
+		}
+		imgData, err := downloadImage(article.Image)
+		if err != nil {
+			util.Red.Printf("Couldn't download lead image %s : %s\n", article.Image, err)
+			break
+		}
+		if lead, _, err := image.Decode(bytes.NewReader(imgData)); err == nil {
+			info.Lead = lead
+		}
+		break
+	}
+	return info
+}
+
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// wrapText splits text into lines no wider than width
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if len(line) > 0 {
+			candidate = line + " " + word
+		}
+		if len(line) > 0 && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawLines draws lines of text starting with the baseline of the first line
+// at y, and returns the y below the last line
+func drawLines(dst draw.Image, face font.Face, ink color.Color, lines []string, x int, y int) int {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(ink), Face: face}
+	lineHeight := face.Metrics().Height.Ceil()
+	for _, line := range lines {
+		d.Dot = fixed.P(x, y)
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return y
+}
+
+// renderCover draws a cover with the title, date, number of articles, source
+// domains and the lead image if there is one
+func renderCover(info coverInfo, width int, height int) (image.Image, error) {
+	cover := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(cover, cover.Bounds(), image.White, image.Point{}, draw.Src)
+
+	margin := int(float64(width) * coverMargin)
+	textWidth := width - 2*margin
+
+	// Dark band on top, the title goes right below it
+	band := height / 40
+	draw.Draw(cover, image.Rect(0, 0, width, band), image.NewUniform(coverInk), image.Point{}, draw.Src)
+
+	// Largest title size that fits in the allowed number of lines
+	var titleFace font.Face
+	var titleLines []string
+	for size := float64(width) * coverTitleSize; ; size *= 0.9 {
+		face, err := newFace(gobold.TTF, size)
+		if err != nil {
+			return nil, err
+		}
+		titleFace, titleLines = face, wrapText(face, info.Title, textWidth)
+		if len(titleLines) <= coverTitleMaxLines || size < float64(width)*coverTitleMinSize {
+			break
+		}
+	}
+	if len(titleLines) > coverTitleMaxLines {
+		titleLines = titleLines[:coverTitleMaxLines]
+		titleLines[coverTitleMaxLines-1] += "…"
+	}
+	y := band + margin + titleFace.Metrics().Ascent.Ceil()
+	y = drawLines(cover, titleFace, coverInk, titleLines, margin, y)
+
+	// Rule and details under the title
+	y += margin / 4
+	draw.Draw(cover, image.Rect(margin, y, margin+textWidth/3, y+max(height/400, 2)), image.NewUniform(coverInk), image.Point{}, draw.Src)
+	textFace, err := newFace(goregular.TTF, float64(width)*coverTextSize)
+	if err != nil {
+		return nil, err
+	}
+	y += margin/2 + textFace.Metrics().Ascent.Ceil()
+	details := info.Date.Format("2 January 2006")
+	if info.Articles == 1 {
+		details += " · 1 article"
+	} else {
+		details += fmt.Sprintf(" · %d articles", info.Articles)
+	}
+	y = drawLines(cover, textFace, coverMuted, []string{details}, margin, y)
+
+	// Source domains at the bottom
+	smallFace, err := newFace(goregular.TTF, float64(width)*coverSmallSize)
+	if err != nil {
+		return nil, err
+	}
+	domains := info.Domains
+	if len(domains) > coverMaxDomains {
+		domains = append(domains[:coverMaxDomains:coverMaxDomains], fmt.Sprintf("+%d more", len(info.Domains)-coverMaxDomains))
+	}
+	domainLines := wrapText(smallFace, strings.Join(domains, " · "), textWidth)
+	lineHeight := smallFace.Metrics().Height.Ceil()
+	bottom := height - margin - len(domainLines)*lineHeight
+	drawLines(cover, smallFace, coverMuted, domainLines, margin, bottom+smallFace.Metrics().Ascent.Ceil())
+
+	// Lead image in the space left between details and domains
+	if info.Lead != nil {
+		area := image.Rect(margin, y+margin/2, width-margin, bottom-margin/2)
+		if area.Dx() > 0 && area.Dy() > height/6 {
+			lead := info.Lead.Bounds()
+			ratio := min(float64(area.Dx())/float64(lead.Dx()), float64(area.Dy())/float64(lead.Dy()))
+			w, h := int(float64(lead.Dx())*ratio), int(float64(lead.Dy())*ratio)
+			x0 := area.Min.X + (area.Dx()-w)/2
+			y0 := area.Min.Y + (area.Dy()-h)/2
+			draw.CatmullRom.Scale(cover, image.Rect(x0, y0, x0+w, y0+h), info.Lead, lead, draw.Over, nil)
+		}
+	}
+
+	return cover, nil
+}
+
+// addCover sets the cover of the book, either the custom cover image from
+// the options or one generated from the articles
+func addCover(book bookWriter, title string, articles []Article, opts Options) error {
+	profile := opts.Profile
+
+	var processed processedImage
+	if len(opts.Cover) > 0 {
+		imgData, err := os.ReadFile(opts.Cover)
+		if err != nil {
+			return err
+		}
+		processed, err = processImage(imgData, profile)
+		if err != nil {
+			return err
+		}
+	} else {
+		info := newCoverInfo(title, articles)
+		cover, err := renderCover(info, profile.Width, profile.Height)
+		if err != nil {
+			return err
+		}
+		var img image.Image = cover
+		if profile.Grayscale || profile.GrayLevels > 0 {
+			img = toGray(img)
+		}
+		// Flat covers compress best as PNG, a lead photo needs JPEG
+		processed, err = encodeImage(img, info.Lead == nil, profile.Quality)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := book.setCover("cover"+processed.ext, processed); err != nil {
+		return err
+	}
+	util.Green.Printf("Added cover (%dKB)\n", len(processed.data)/1024)
+	return nil
+}
@@ -0,0 +1,80 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderCover(t *testing.T) {
+	var articles []Article
+	for i := 0; i < coverMaxDomains+3; i++ {
+		articles = append(articles, Article{Source: fmt.Sprintf("https://www.site%d.example.com/post", i)})
+	}
+	// The same site with and without www counts once
+	articles = append(articles, Article{Source: "https://site0.example.com/other"}, Article{Source: "not a url"})
+	articles[1].Image = dataURL("image/png", testImage(t))
+
+	title := strings.Repeat("A very long title that has to wrap over many lines ", 8)
+	info := newCoverInfo(title, articles)
+	if len(info.Domains) != coverMaxDomains+3 || info.Articles != len(articles) || info.Lead == nil {
+		t.Fatalf("unexpected cover details %+v", info)
+	}
+	for _, size := range []image.Point{{600, 800}, {1264, 1680}, {200, 150}} {
+		cover, err := renderCover(info, size.X, size.Y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cover.Bounds().Size(); got != size {
+			t.Errorf("expected a %v cover, got %v", size, got)
+		}
+	}
+}
+
+func TestAddCover(t *testing.T) {
+	opts, err := NewOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	articles := []Article{{Source: "https://example.com/post"}}
+	coverSize := func(book *fb2Writer) image.Point {
+		t.Helper()
+		if len(book.binaries) != 1 || book.cover != book.binaries[0].id {
+			t.Fatalf("expected the cover to be stored, got %d images", len(book.binaries))
+		}
+		img, _, err := image.Decode(bytes.NewReader(book.binaries[0].data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.Bounds().Size()
+	}
+
+	generated := newFB2Writer("Book", opts)
+	if err := addCover(generated, "Book", articles, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := coverSize(generated); got != image.Pt(opts.Profile.Width, opts.Profile.Height) {
+		t.Errorf("expected a generated cover of the profile size, got %v", got)
+	}
+
+	opts.Cover = filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(opts.Cover, testImage(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	custom := newFB2Writer("Book", opts)
+	if err := addCover(custom, "Book", articles, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := coverSize(custom); got != image.Pt(40, 30) {
+		t.Errorf("expected the custom cover, got a %v image", got)
+	}
+
+	opts.Cover = filepath.Join(t.TempDir(), "missing.png")
+	if err := addCover(newFB2Writer("Book", opts), "Book", articles, opts); err == nil {
+		t.Error("expected an error for a missing cover image")
+	}
+}
@@ -0,0 +1,279 @@
+package epubgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// DefaultCrawlLimit is how many links of an index or sitemap are kept
+const DefaultCrawlLimit = 50
+
+// Orders of the links collected from an index or sitemap
+const (
+	CrawlOrderPage   = "page"   // As they appear on the index or sitemap
+	CrawlOrderNewest = "newest" // Newest first, links without a date last
+	CrawlOrderOldest = "oldest" // Oldest first, links without a date last
+)
+
+// CrawlOrderNames lists the orders of crawled links
+func CrawlOrderNames() []string {
+	return []string{CrawlOrderPage, CrawlOrderNewest, CrawlOrderOldest}
+}
+
+// CrawlOptions picks the article links of an index page or sitemap
+type CrawlOptions struct {
+	// Elements of an index page holding the links, or the links themselves.
+	// Every link of the page when empty.
+	Selector string
+	// Links must match it. Without one only links of the site are kept.
+	Match *regexp.Regexp
+	// Most links kept, DefaultCrawlLimit when 0
+	Limit int
+	// One of the CrawlOrder constants, page order when empty
+	Order string
+}
+
+// crawlLink is an article link with its date when the index tells
+type crawlLink struct {
+	URL  string
+	Date time.Time
+}
+
+// Files linked from indexes that are not articles
+var crawlSkipped = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".svg": true,
+	".css": true, ".js": true, ".xml": true, ".rss": true, ".atom": true, ".zip": true,
+	".mp3": true, ".mp4": true, ".ico": true,
+}
+
+// Where a link of an index sits with its date
+const crawlItems = "li, article, tr, dt, dd, p, h2, h3"
+
+type sitemap struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// Crawl collects the article links of an index page or sitemap, deduplicated
+// and capped, in the order asked for. Returns the links and a title for the
+// book, the title of the index page or the site of a sitemap.
+func Crawl(indexURL string, opts CrawlOptions) ([]string, string, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := fetchIndex(indexURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var links []crawlLink
+	title := strings.TrimPrefix(base.Hostname(), "www.")
+	if isSitemap(data) {
+		links, err = sitemapLinks(data, 0)
+	} else {
+		var pageTitle string
+		links, pageTitle, err = indexLinks(data, base, opts.Selector)
+		if len(pageTitle) > 0 {
+			title = pageTitle
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	links = filterLinks(links, base, opts.Match)
+	switch opts.Order {
+	case CrawlOrderNewest, CrawlOrderOldest:
+		sort.SliceStable(links, func(i, j int) bool {
+			if links[i].Date.IsZero() || links[j].Date.IsZero() {
+				return !links[i].Date.IsZero() && links[j].Date.IsZero()
+			}
+			if opts.Order == CrawlOrderNewest {
+				return links[i].Date.After(links[j].Date)
+			}
+			return links[i].Date.Before(links[j].Date)
+		})
+	case "", CrawlOrderPage:
+	default:
+		return nil, "", fmt.Errorf("unknown order %s, expected one of %s", opts.Order, strings.Join(CrawlOrderNames(), ", "))
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultCrawlLimit
+	}
+	if len(links) > limit {
+		util.Magenta.Printf("Found %d links on %s, keeping the first %d\n", len(links), indexURL, limit)
+		links = links[:limit]
+	}
+	if len(links) == 0 {
+		return nil, "", fmt.Errorf("no article links found on %s", indexURL)
+	}
+	util.CyanBold.Printf("Collected %d links from %s\n", len(links), indexURL)
+	urls := make([]string, len(links))
+	for i, link := range links {
+		urls[i] = link.URL
+	}
+	return urls, title, nil
+}
+
+// fetchIndex fetches an index page or sitemap, unpacking gzipped sitemaps
+func fetchIndex(indexURL string) ([]byte, error) {
+	data, status, err := fetchPage(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, errors.New(http.StatusText(status))
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return data, nil
+}
+
+// isSitemap reports whether data is a sitemap or sitemap index
+func isSitemap(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "urlset" || start.Name.Local == "sitemapindex"
+		}
+	}
+}
+
+// sitemapLinks reads the pages of a sitemap, the sitemaps of an index are
+// fetched one level deep
+func sitemapLinks(data []byte, depth int) ([]crawlLink, error) {
+	var sm sitemap
+	if err := xml.Unmarshal(data, &sm); err != nil {
+		return nil, err
+	}
+	var links []crawlLink
+	for _, u := range sm.URLs {
+		links = append(links, crawlLink{URL: strings.TrimSpace(u.Loc), Date: parseDate(u.LastMod)})
+	}
+	if depth > 0 {
+		return links, nil
+	}
+	for _, child := range sm.Sitemaps {
+		loc := strings.TrimSpace(child.Loc)
+		childData, err := fetchIndex(loc)
+		if err != nil {
+			util.Red.Printf("Couldn't fetch the sitemap %s : %s\n", loc, err)
+			continue
+		}
+		childLinks, err := sitemapLinks(childData, depth+1)
+		if err != nil {
+			util.Red.Printf("Couldn't read the sitemap %s : %s\n", loc, err)
+			continue
+		}
+		links = append(links, childLinks...)
+	}
+	return links, nil
+}
+
+// indexLinks collects the links of an index page inside the elements of
+// selector, dated by the time element of the list item they are in
+func indexLinks(data []byte, base *url.URL, selector string) ([]crawlLink, string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if href, ok := doc.Find("base[href]").Attr("href"); ok {
+		if resolved, err := base.Parse(href); err == nil {
+			base = resolved
+		}
+	}
+	if len(selector) == 0 {
+		selector = "a[href]"
+	}
+	var links []crawlLink
+	doc.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		anchors := s.Filter("a[href]")
+		if anchors.Length() == 0 {
+			anchors = s.Find("a[href]")
+		}
+		anchors.Each(func(_ int, a *goquery.Selection) {
+			link, err := base.Parse(strings.TrimSpace(a.AttrOr("href", "")))
+			if err != nil {
+				return
+			}
+			links = append(links, crawlLink{URL: link.String(), Date: linkDate(a)})
+		})
+	})
+	return links, strings.TrimSpace(doc.Find("title").First().Text()), nil
+}
+
+// linkDate is the date next to a link in its list item, zero if none
+func linkDate(a *goquery.Selection) time.Time {
+	item := a.Closest(crawlItems)
+	if item.Length() == 0 {
+		return time.Time{}
+	}
+	stamp := item.Find("time").First()
+	if stamp.Length() == 0 {
+		return time.Time{}
+	}
+	if date := parseDate(stamp.AttrOr("datetime", "")); !date.IsZero() {
+		return date
+	}
+	return parseDate(stamp.Text())
+}
+
+// filterLinks drops the links that aren't articles and the repeated ones.
+// Without a pattern only links of the site of the index are kept.
+func filterLinks(links []crawlLink, base *url.URL, match *regexp.Regexp) []crawlLink {
+	site := strings.TrimPrefix(strings.ToLower(base.Hostname()), "www.")
+	seen := map[string]bool{base.String(): true}
+	var kept []crawlLink
+	for _, link := range links {
+		u, err := url.Parse(link.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		if seen[u.String()] || crawlSkipped[strings.ToLower(path.Ext(u.Path))] {
+			continue
+		}
+		seen[u.String()] = true
+		if match != nil {
+			if !match.MatchString(u.String()) {
+				continue
+			}
+		} else if strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") != site || u.Path == "" || u.Path == "/" {
+			continue
+		}
+		kept = append(kept, crawlLink{URL: u.String(), Date: link.Date})
+	}
+	return kept
+}
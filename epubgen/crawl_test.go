@@ -0,0 +1,92 @@
+package epubgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const crawlIndex = `<html><head><title>Essays</title></head><body>
+<nav><a href="/">Home</a><a href="/about.html">About</a></nav>
+<ul class="posts">
+<li><a href="/alien.html">Alien</a> <time datetime="2021-05-01">May 2021</time></li>
+<li><a href="/hwh.html#top">How to Work Hard</a> <time datetime="2022-06-01">June 2022</time></li>
+<li><a href="hwh.html">How to Work Hard again</a></li>
+<li><a href="/cover.png">Cover</a></li>
+<li><a href="https://elsewhere.com/essay.html">Elsewhere</a> <time>2023-01-01</time></li>
+<li><a href="/undated.html">Undated</a></li>
+</ul></body></html>`
+
+func crawlServer(t *testing.T) *httptest.Server {
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	writer.Write([]byte(`<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>https://example.com/blog/old</loc><lastmod>2020-01-01</lastmod></url>
+<url><loc>https://example.com/tags/go</loc></url></urlset>`))
+	writer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/essays.html":
+			w.Write([]byte(crawlIndex))
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0"?><sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>` + "http://" + r.Host + `/posts.xml</loc></sitemap><sitemap><loc>` + "http://" + r.Host + `/old.xml.gz</loc></sitemap></sitemapindex>`))
+		case "/posts.xml":
+			w.Write([]byte(`<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>https://example.com/blog/new</loc><lastmod>2024-03-05T10:00:00+00:00</lastmod></url>
+<url><loc>https://example.com/blog/middle</loc><lastmod>2022-01-01</lastmod></url></urlset>`))
+		case "/old.xml.gz":
+			w.Write(gzipped.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCrawlIndex(t *testing.T) {
+	server := crawlServer(t)
+	links, title, err := Crawl(server.URL+"/essays.html", CrawlOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{server.URL + "/about.html", server.URL + "/alien.html", server.URL + "/hwh.html", server.URL + "/undated.html"}
+	if title != "Essays" || strings.Join(links, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected links %v titled %q", links, title)
+	}
+
+	links, _, err = Crawl(server.URL+"/essays.html", CrawlOptions{Selector: "ul.posts li", Order: CrawlOrderNewest, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(links, " ") != server.URL+"/hwh.html "+server.URL+"/alien.html" {
+		t.Errorf("expected the newest posts of the list, got %v", links)
+	}
+
+	links, _, err = Crawl(server.URL+"/essays.html", CrawlOptions{Match: regexp.MustCompile(`essay|alien`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(links, " ") != server.URL+"/alien.html https://elsewhere.com/essay.html" {
+		t.Errorf("expected the matching links of any site, got %v", links)
+	}
+}
+
+func TestCrawlSitemap(t *testing.T) {
+	server := crawlServer(t)
+	links, _, err := Crawl(server.URL+"/sitemap.xml", CrawlOptions{Match: regexp.MustCompile(`/blog/`), Order: CrawlOrderOldest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(links, " ") != "https://example.com/blog/old https://example.com/blog/middle https://example.com/blog/new" {
+		t.Errorf("unexpected sitemap links %v", links)
+	}
+	if _, _, err := Crawl(server.URL+"/sitemap.xml", CrawlOptions{Match: regexp.MustCompile(`/nothing/`)}); err == nil {
+		t.Error("expected an error without any matching link")
+	}
+}
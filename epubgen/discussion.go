@@ -0,0 +1,181 @@
+package epubgen
+
+import (
+	"fmt"
+	htmlutil "html"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// DefaultCommentDepth is how deep replies of discussion threads are nested
+// before being collapsed
+const DefaultCommentDepth = 6
+
+// DiscussionOptions tunes how discussion threads like Hacker News items or
+// Reddit posts or Mastodon threads are rendered
+type DiscussionOptions struct {
+	// Replies nested deeper are collapsed, 0 keeps every level
+	MaxDepth int
+	// Comments scored lower are collapsed with their replies, 0 keeps all.
+	// Only applies to sites showing comment scores.
+	MinScore int
+	// Put the linked article before the comments when the thread is about one
+	WithArticle bool
+	// Only keep the posts the author chained into a Mastodon thread, without
+	// the replies of other people
+	AuthorOnly bool
+}
+
+var discussionOptions = DiscussionOptions{MaxDepth: DefaultCommentDepth}
+
+// SetDiscussionOptions sets how discussion threads are rendered
+func SetDiscussionOptions(opts DiscussionOptions) {
+	if opts.MaxDepth < 0 {
+		opts.MaxDepth = 0
+	}
+	discussionOptions = opts
+}
+
+// comment is a comment of a discussion thread with its replies
+type comment struct {
+	Author   string
+	Body     string // html
+	Score    int
+	HasScore bool
+	Created  time.Time
+	Replies  []comment
+	// Replies the site didn't return, loaded on demand on the page
+	More int
+}
+
+// replyCount is the number of replies under c at any depth
+func (c comment) replyCount() int {
+	count := c.More
+	for _, reply := range c.Replies {
+		count += 1 + reply.replyCount()
+	}
+	return count
+}
+
+// discussion is a thread, with the link it is about if any
+type discussion struct {
+	Title    string
+	Author   string
+	Link     string // the linked article, empty for text posts
+	Body     string // html of the post itself
+	Score    int
+	HasScore bool
+	Created  time.Time
+	Comments []comment
+	More     int // top level comments the site didn't return
+}
+
+// renderComments writes the comment tree as html, replies are indented with
+// nested blockquotes
+func renderComments(out *strings.Builder, comments []comment, depth int, opts DiscussionOptions) {
+	for _, c := range comments {
+		tag := "div"
+		if depth > 0 {
+			tag = "blockquote"
+		}
+		out.WriteString("<" + tag + ` class="comment">`)
+		collapsed := opts.MinScore != 0 && c.HasScore && c.Score < opts.MinScore
+		out.WriteString(commentMeta(c, collapsed))
+		if !collapsed {
+			out.WriteString(c.Body)
+		}
+		hidden := c.replyCount()
+		switch {
+		case hidden == 0:
+		case collapsed || (opts.MaxDepth > 0 && depth+1 >= opts.MaxDepth):
+			out.WriteString(fmt.Sprintf(`<p class="comment-hidden">%s hidden</p>`, plural(hidden, "reply", "replies")))
+		default:
+			renderComments(out, c.Replies, depth+1, opts)
+			if c.More > 0 {
+				out.WriteString(fmt.Sprintf(`<p class="comment-hidden">%s more not loaded</p>`, plural(c.More, "reply", "replies")))
+			}
+		}
+		out.WriteString("</" + tag + ">")
+	}
+}
+
+// commentMeta is the line above a comment with its author, score and date
+func commentMeta(c comment, collapsed bool) string {
+	parts := []string{"<strong>" + htmlutil.EscapeString(c.Author) + "</strong>"}
+	if c.HasScore {
+		parts = append(parts, plural(c.Score, "point", "points"))
+	}
+	if !c.Created.IsZero() {
+		parts = append(parts, c.Created.Format("2 Jan 2006"))
+	}
+	if collapsed {
+		parts = append(parts, "collapsed")
+	}
+	return `<p class="comment-meta">` + strings.Join(parts, " · ") + "</p>"
+}
+
+func plural(n int, one string, many string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// discussionArticle renders a thread as an article, the comments get their
+// own section after the post, or after the linked article when asked for
+func discussionArticle(d discussion, source string, opts DiscussionOptions) Article {
+	var content strings.Builder
+	byline := d.Author
+	if len(d.Link) > 0 && opts.WithArticle {
+		if linked, err := linkedArticle(d.Link); err != nil {
+			util.Red.Printf("Couldn't fetch %s linked by %s, keeping the comments only : %s\n", d.Link, source, err)
+		} else {
+			content.WriteString(linked.Content)
+			if len(linked.Byline) > 0 {
+				byline = linked.Byline
+			}
+		}
+	}
+	if len(d.Link) > 0 {
+		link := htmlutil.EscapeString(d.Link)
+		content.WriteString(`<p class="discussion-link">Link: <a href="` + link + `">` + link + "</a></p>")
+	}
+	content.WriteString(d.Body)
+
+	meta := []string{"Posted by " + htmlutil.EscapeString(d.Author)}
+	if d.HasScore {
+		meta = append(meta, plural(d.Score, "point", "points"))
+	}
+	count := d.More
+	for _, c := range d.Comments {
+		count += 1 + c.replyCount()
+	}
+	meta = append(meta, plural(count, "comment", "comments"))
+	content.WriteString(`<h2>Comments</h2><p class="comment-meta">` + strings.Join(meta, " · ") + "</p>")
+	renderComments(&content, d.Comments, 0, opts)
+	if d.More > 0 {
+		content.WriteString(fmt.Sprintf(`<p class="comment-hidden">%s more not loaded</p>`, plural(d.More, "comment", "comments")))
+	}
+
+	article := NewArticle(d.Title, byline, content.String(), source)
+	article.Published = d.Created
+	// The comments are listed in the table of contents
+	article.MinTOCDepth = 1
+	return article
+}
+
+// linkedArticle fetches the article a thread links to with readability,
+// adapters are skipped as the link could be another thread
+func linkedArticle(link string) (Article, error) {
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return Article{}, err
+	}
+	if linkURL.Scheme != "http" && linkURL.Scheme != "https" {
+		return Article{}, fmt.Errorf("unsupported link %s", link)
+	}
+	return fetchWithReadability(link, linkURL)
+}
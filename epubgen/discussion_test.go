@@ -0,0 +1,100 @@
+package epubgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderComments(t *testing.T) {
+	comments := []comment{
+		{Author: "alice", Body: "<p>Top</p>", Score: 10, HasScore: true, Replies: []comment{
+			{Author: "bob", Body: "<p>Reply</p>", Score: 3, HasScore: true, Replies: []comment{
+				{Author: "carol", Body: "<p>Deep</p>", Score: 5, HasScore: true},
+			}},
+		}},
+		{Author: "troll", Body: "<p>Buried</p>", Score: -4, HasScore: true, Replies: []comment{
+			{Author: "dave", Body: "<p>Answer</p>", Score: 2, HasScore: true},
+		}},
+	}
+	var out strings.Builder
+	renderComments(&out, comments, 0, DiscussionOptions{MaxDepth: 2, MinScore: 1})
+	html := out.String()
+	for _, want := range []string{
+		`<div class="comment"><p class="comment-meta"><strong>alice</strong> · 10 points</p><p>Top</p><blockquote class="comment">`,
+		"<strong>bob</strong> · 3 points</p><p>Reply</p>",
+		"-4 points · collapsed",
+		`<p class="comment-hidden">1 reply hidden</p>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in %s", want, html)
+		}
+	}
+	for _, hidden := range []string{"Deep", "Buried", "Answer"} {
+		if strings.Contains(html, hidden) {
+			t.Errorf("expected %q to be collapsed in %s", hidden, html)
+		}
+	}
+}
+
+func TestHackerNewsDiscussion(t *testing.T) {
+	points := 120
+	d := hackerNewsDiscussion(hnItem{
+		Author: "pg", Title: "Show HN", URL: "https://example.com", Points: &points, CreatedAt: 1700000000,
+		Children: []hnItem{
+			{Author: "a", Text: "First<p>Second", Children: []hnItem{{Author: "b", Text: "<p>Reply</p>"}}},
+			{Children: []hnItem{{Author: "c", Text: "Orphan"}}},
+			{},
+		},
+	})
+	if d.Title != "Show HN" || d.Link != "https://example.com" || d.Score != 120 || d.Created.Year() != 2023 {
+		t.Errorf("unexpected thread %+v", d)
+	}
+	if len(d.Comments) != 2 || d.Comments[0].Body != "<p>First<p>Second</p>" || d.Comments[0].HasScore {
+		t.Errorf("unexpected comments %+v", d.Comments)
+	}
+	if d.Comments[1].Author != "[deleted]" || d.Comments[1].Replies[0].Body != "<p>Orphan</p>" {
+		t.Errorf("expected the deleted comment kept for its reply, got %+v", d.Comments[1])
+	}
+}
+
+func TestRedditDiscussion(t *testing.T) {
+	data := `[
+		{"data": {"children": [{"kind": "t3", "data": {"title": "A post", "author": "op", "score": 42, "is_self": true,
+			"selftext_html": "&lt;div class=\"md\"&gt;&lt;p&gt;Text&lt;/p&gt;&lt;/div&gt;", "created_utc": 1700000000.0}}]}},
+		{"data": {"children": [
+			{"kind": "t1", "data": {"author": "a", "score": 7, "body_html": "<div class=\"md\"><p>Hi</p></div>",
+				"replies": {"data": {"children": [
+					{"kind": "t1", "data": {"author": "b", "score": 1, "body_html": "<p>Reply</p>", "replies": ""}},
+					{"kind": "more", "data": {"count": 3}}
+				]}}}},
+			{"kind": "more", "data": {"count": 12}}
+		]}}
+	]`
+	d, err := redditDiscussion([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Title != "A post" || d.Link != "" || d.Body != `<div class="md"><p>Text</p></div>` || d.More != 12 {
+		t.Errorf("unexpected thread %+v", d)
+	}
+	if len(d.Comments) != 1 || d.Comments[0].More != 3 || d.Comments[0].Replies[0].Author != "b" || d.Comments[0].replyCount() != 4 {
+		t.Errorf("unexpected comments %+v", d.Comments)
+	}
+
+	article := discussionArticle(d, "https://www.reddit.com/r/golang/comments/abc/a_post/", DiscussionOptions{})
+	if !strings.Contains(article.Content, "<h2>Comments</h2>") || !strings.Contains(article.Content, "17 comments") {
+		t.Errorf("unexpected article %s", article.Content)
+	}
+}
+
+func TestDiscussionCommentsInTOC(t *testing.T) {
+	d := discussion{Title: "A post", Author: "op", Body: "<p>Text</p>", Comments: []comment{{Author: "a", Body: "<p>Hi</p>"}}}
+	article := discussionArticle(d, "https://www.reddit.com/r/golang/comments/abc/a_post/", DiscussionOptions{})
+	path, err := makeBook([]Article{article}, "Thread", t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nav := bookNav(t, path); !strings.Contains(nav, ">Comments</a>") {
+		t.Errorf("expected the comments in the table of contents without --toc-depth, got %s", nav)
+	}
+}
@@ -0,0 +1,139 @@
+package epubgen
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nikhil1raghav/kindle-send/config"
+	"golang.org/x/image/draw"
+)
+
+// Share of darkest and brightest pixels ignored when stretching levels
+const autoLevelsClip = 0.01
+
+// einkProcess prepares an image for an e-ink screen. It is converted to
+// grayscale, optionally auto-levelled and sharpened, then reduced to the
+// number of gray levels of the profile, with Floyd–Steinberg dithering if
+// enabled so gradients don't turn into bands.
+func einkProcess(img image.Image, profile config.Profile) *image.Paletted {
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		bounds := img.Bounds()
+		gray = image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	}
+	if profile.AutoLevels {
+		gray = autoLevels(gray)
+	}
+	if profile.Sharpen {
+		gray = sharpen(gray)
+	}
+	return quantize(gray, profile.GrayLevels, profile.Dither)
+}
+
+// grayPalette returns levels evenly spaced shades from black to white
+func grayPalette(levels int) color.Palette {
+	palette := make(color.Palette, levels)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i * 255 / (levels - 1))}
+	}
+	return palette
+}
+
+// autoLevels stretches the histogram so the darkest pixels become black and
+// the brightest white, which brings back contrast in washed out screenshots
+func autoLevels(g *image.Gray) *image.Gray {
+	bounds := g.Bounds()
+	var histogram [256]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			histogram[g.GrayAt(x, y).Y]++
+		}
+	}
+
+	clip := int(float64(bounds.Dx()*bounds.Dy()) * autoLevelsClip)
+	low, high := 0, 255
+	for count := 0; low < 255; low++ {
+		count += histogram[low]
+		if count > clip {
+			break
+		}
+	}
+	for count := 0; high > 0; high-- {
+		count += histogram[high]
+		if count > clip {
+			break
+		}
+	}
+	if high <= low {
+		return g
+	}
+
+	var lookup [256]uint8
+	for v := range lookup {
+		stretched := (v - low) * 255 / (high - low)
+		lookup[v] = uint8(min(max(stretched, 0), 255))
+	}
+	out := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.SetGray(x, y, color.Gray{Y: lookup[g.GrayAt(x, y).Y]})
+		}
+	}
+	return out
+}
+
+// sharpen applies a 3x3 sharpening kernel, text in screenshots gets crisper
+// after being scaled down
+func sharpen(g *image.Gray) *image.Gray {
+	bounds := g.Bounds()
+	out := image.NewGray(bounds)
+	at := func(x, y int) int {
+		x = min(max(x, bounds.Min.X), bounds.Max.X-1)
+		y = min(max(y, bounds.Min.Y), bounds.Max.Y-1)
+		return int(g.GrayAt(x, y).Y)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := 5*at(x, y) - at(x-1, y) - at(x+1, y) - at(x, y-1) - at(x, y+1)
+			out.SetGray(x, y, color.Gray{Y: uint8(min(max(v, 0), 255))})
+		}
+	}
+	return out
+}
+
+// quantize reduces g to the given number of gray levels, spreading the
+// rounding error to the neighbouring pixels when dither is set
+func quantize(g *image.Gray, levels int, dither bool) *image.Paletted {
+	levels = min(max(levels, 2), 256)
+	bounds := g.Bounds()
+	width := bounds.Dx()
+	out := image.NewPaletted(image.Rect(0, 0, width, bounds.Dy()), grayPalette(levels))
+	step := 255 / float64(levels-1)
+
+	// Errors carried to the current and the next row, padded by one on each side
+	current := make([]float64, width+2)
+	next := make([]float64, width+2)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < width; x++ {
+			v := float64(g.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y) + current[x+1]
+			idx := int(math.Round(v / step))
+			idx = min(max(idx, 0), levels-1)
+			out.SetColorIndex(x, y, uint8(idx))
+
+			if dither {
+				diff := v - float64(idx)*step
+				current[x+2] += diff * 7 / 16
+				next[x] += diff * 3 / 16
+				next[x+1] += diff * 5 / 16
+				next[x+2] += diff * 1 / 16
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = 0
+		}
+	}
+	return out
+}
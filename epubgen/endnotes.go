@@ -0,0 +1,152 @@
+package epubgen
+
+import (
+	"fmt"
+	htmlutil "html"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Where external links are collected as endnotes
+const (
+	EndnotesOff     = ""
+	EndnotesArticle = "article" // A Links section at the end of every article
+	EndnotesBook    = "book"    // One Links section at the end of the book
+)
+
+const endnotesTitle = "Links"
+
+// checkEndnotes returns an error if mode isn't one of the Endnotes constants
+func checkEndnotes(mode string) error {
+	if mode != EndnotesOff && mode != EndnotesArticle && mode != EndnotesBook {
+		return fmt.Errorf("unknown endnotes mode %q, use %s or %s", mode, EndnotesArticle, EndnotesBook)
+	}
+	return nil
+}
+
+// endnote is an external url and the places it is referenced from
+type endnote struct {
+	URL  string
+	Refs []string // file#id of every reference
+}
+
+// endnotes collects the external links of an article or of the whole book
+type endnotes struct {
+	prefix string // Prefix of ids, keeps them unique within the book
+	file   string // Section the notes are written to
+	notes  []endnote
+	byURL  map[string]int
+	refs   int
+}
+
+func newEndnotes(prefix string, file string) *endnotes {
+	return &endnotes{
+		prefix: prefix,
+		file:   file,
+		byURL:  make(map[string]int),
+	}
+}
+
+func (n *endnotes) noteID(num int) string {
+	return fmt.Sprintf("%snote-%d", n.prefix, num)
+}
+
+// externalURL returns the url a link points to if it leaves the book, links
+// within the page the article came from are anchors and stay as they are
+func externalURL(href string, source string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", false
+	}
+	if len(u.Fragment) > 0 {
+		if src, err := url.Parse(source); err == nil {
+			page := *u
+			page.Fragment = ""
+			src.Fragment = ""
+			if page.String() == src.String() {
+				return "", false
+			}
+		}
+	}
+	return u.String(), true
+}
+
+// add returns the number of the note for a url, the same url always gets
+// the same number
+func (n *endnotes) add(link string, ref string) int {
+	idx, ok := n.byURL[link]
+	if !ok {
+		n.notes = append(n.notes, endnote{URL: link})
+		idx = len(n.notes) - 1
+		n.byURL[link] = idx
+	}
+	n.notes[idx].Refs = append(n.notes[idx].Refs, ref)
+	return idx + 1
+}
+
+// rewrite turns the external links of content, which is written to file,
+// into references to numbered notes. Links around images are left alone.
+func (n *endnotes) rewrite(content string, file string, source string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+	changed := false
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		if len(strings.TrimSpace(a.Text())) == 0 {
+			return
+		}
+		href, _ := a.Attr("href")
+		link, ok := externalURL(href, source)
+		if !ok {
+			return
+		}
+		n.refs++
+		refID := fmt.Sprintf("%sref-%d", n.prefix, n.refs)
+		num := n.add(link, file+"#"+refID)
+
+		// The link text stays in place as plain text, the number links to the note
+		node := a.Get(0)
+		node.Data, node.DataAtom = "span", atom.Span
+		node.Attr = []html.Attribute{{Key: "class", Val: "endnote-link"}}
+		a.AfterHtml(fmt.Sprintf(`<sup class="endnote-ref"><a id="%s" href="%s#%s">%d</a></sup>`, refID, n.file, n.noteID(num), num))
+		changed = true
+	})
+	if !changed {
+		return content
+	}
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return content
+	}
+	return body
+}
+
+func (n *endnotes) empty() bool {
+	return len(n.notes) == 0
+}
+
+// render writes the notes as a numbered list, every note links back to
+// where it was referenced
+func (n *endnotes) render() string {
+	var out strings.Builder
+	out.WriteString(`<div class="endnotes"><h2>` + endnotesTitle + "</h2><ol>")
+	for idx, note := range n.notes {
+		link := htmlutil.EscapeString(note.URL)
+		out.WriteString(fmt.Sprintf(`<li id="%s"><a href="%s">%s</a>`, n.noteID(idx+1), link, link))
+		for i, ref := range note.Refs {
+			label := "↩"
+			if len(note.Refs) > 1 {
+				label = fmt.Sprintf("↩%d", i+1)
+			}
+			out.WriteString(fmt.Sprintf(` <a class="endnote-back" href="%s">%s</a>`, ref, label))
+		}
+		out.WriteString("</li>")
+	}
+	out.WriteString("</ol></div>")
+	return out.String()
+}
@@ -0,0 +1,35 @@
+package epubgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEndnotesRewriteLinks(t *testing.T) {
+	notes := newEndnotes("", "links.xhtml")
+	content := `<p><a href="https://example.com/a">first</a> and <a href="#top">anchor</a>
+<a href="https://blog.test/post#part">same page</a> and <a href="https://example.com/a">again</a></p>`
+
+	out := notes.rewrite(content, "article001.xhtml", "https://blog.test/post")
+
+	if len(notes.notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(notes.notes))
+	}
+	if refs := notes.notes[0].Refs; len(refs) != 2 || refs[0] != "article001.xhtml#ref-1" || refs[1] != "article001.xhtml#ref-2" {
+		t.Errorf("unexpected references %v", refs)
+	}
+	if strings.Contains(out, `href="https://example.com/a"`) {
+		t.Errorf("external link left in place: %s", out)
+	}
+	if !strings.Contains(out, `href="#top"`) || !strings.Contains(out, `href="https://blog.test/post#part"`) {
+		t.Errorf("in-document anchors should be kept: %s", out)
+	}
+	if !strings.Contains(out, `<a id="ref-2" href="links.xhtml#note-1">1</a>`) {
+		t.Errorf("repeated url should reuse note 1: %s", out)
+	}
+
+	rendered := notes.render()
+	if !strings.Contains(rendered, `<li id="note-1"><a href="https://example.com/a">`) || !strings.Contains(rendered, `href="article001.xhtml#ref-2"`) {
+		t.Errorf("unexpected notes: %s", rendered)
+	}
+}
@@ -3,29 +3,22 @@ package epubgen
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	htmlutil "html"
-	"image"
-	"image/jpeg"
-	_ "image/png" // Register PNG decoder
 	_ "image/gif" // Register GIF decoder
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/bmaupin/go-epub"
 	"github.com/go-shiori/go-readability"
 	"github.com/gosimple/slug"
 	"github.com/nikhil1raghav/kindle-send/config"
 	"github.com/nikhil1raghav/kindle-send/util"
-	"golang.org/x/image/draw"
-	"golang.org/x/net/html"
 )
 
 // httpClient is used for fetching URLs. Can be set with SetHTTPClient to include cookies.
@@ -44,231 +37,217 @@ func getHTTPClient() *http.Client {
 	return &http.Client{Timeout: 30 * time.Second}
 }
 
+// Article is a readable article along with where it came from
+type Article struct {
+	readability.Article
+	// Url the article was fetched from, or the source given for a manual article
+	Source string
+	// Publication date found in the page metadata, zero if unknown
+	Published time.Time
+	// Language code like "en" or "de" when the source tells, empty if unknown
+	Language string
+	// Depth the article is split at in the table of contents even when the
+	// options ask for less, for articles made of sections like comments
+	MinTOCDepth int
+}
+
+// epubmaker writes books as EPUB, or KEPUB for Kobo readers
 type epubmaker struct {
-	Epub      *epub.Epub
-	downloads map[string]string
+	Epub *epub.Epub
+	// path of the stylesheet linked from every section
+	css  string
+	opts Options
 }
 
-func NewEpubmaker(title string) *epubmaker {
-	downloadMap := make(map[string]string)
+func NewEpubmaker(title string, opts Options) *epubmaker {
 	return &epubmaker{
-		Epub:      epub.NewEpub(title),
-		downloads: downloadMap,
+		Epub: epub.NewEpub(title),
+		opts: opts,
 	}
 }
 
-func fetchReadable(pageURL string) (readability.Article, error) {
-	client := getHTTPClient()
-
-	req, err := http.NewRequest("GET", pageURL, nil)
-	if err != nil {
-		return readability.Article{}, err
-	}
-
-	// Set a browser-like User-Agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return readability.Article{}, err
-	}
-	defer resp.Body.Close()
+// addImage adds an image to the epub, go-epub reads it from the data url
+// when writing the book
+func (e *epubmaker) addImage(name string, img processedImage) (string, error) {
+	return e.Epub.AddImage(dataURL(img.mediaType, img.data), name)
+}
 
-	parsedURL, err := url.Parse(pageURL)
+func (e *epubmaker) setCover(name string, img processedImage) error {
+	coverRef, err := e.addImage(name, img)
 	if err != nil {
-		return readability.Article{}, err
+		return err
 	}
-
-	return readability.FromReader(resp.Body, parsedURL)
+	e.Epub.SetCover(coverRef, "")
+	return nil
 }
 
-// Point remote image link to downloaded image
-func (e *epubmaker) changeRefs(i int, img *goquery.Selection) {
-	img.RemoveAttr("loading")
-	img.RemoveAttr("srcset")
-	imgSrc, exists := img.Attr("src")
-	if exists {
-		if _, ok := e.downloads[imgSrc]; ok {
-			util.Green.Printf("Setting img src from %s to %s \n", imgSrc, e.downloads[imgSrc])
-			img.SetAttr("src", e.downloads[imgSrc])
-		}
-	}
+func (e *epubmaker) write(filepath string) error {
+	return e.Epub.Write(filepath)
 }
 
-// Image compression settings
-const (
-	maxImageWidth  = 800  // Max width for Kindle readability
-	maxImageHeight = 1200 // Max height
-	jpegQuality    = 75   // JPEG quality (1-100)
-)
-
-// downloadAndCompressImage downloads an image, resizes if needed, and compresses as JPEG
-func downloadAndCompressImage(imgURL string) (string, error) {
+// fetchPage downloads a page and returns it with the status code of the response
+func fetchPage(pageURL string) ([]byte, int, error) {
 	client := getHTTPClient()
 
-	req, err := http.NewRequest("GET", imgURL, nil)
+	req, err := http.NewRequest("GET", pageURL, nil)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
+
+	// Set a browser-like User-Agent
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to download image: " + resp.Status)
-	}
-
-	// Read the image data
-	imgData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
+	page, err := io.ReadAll(resp.Body)
+	return page, resp.StatusCode, err
+}
 
-	// Decode the image
-	img, _, err := image.Decode(bytes.NewReader(imgData))
+// fetchReadable fetches the readable version of a page, along with the
+// following pages of articles split over several pages. Sites with an
+// adapter are extracted by it instead.
+func fetchReadable(pageURL string) (Article, error) {
+	parsedURL, err := url.Parse(pageURL)
 	if err != nil {
-		return "", err
+		return Article{}, err
 	}
 
-	bounds := img.Bounds()
-	origWidth := bounds.Dx()
-	origHeight := bounds.Dy()
-
-	// Calculate new dimensions if resizing needed
-	newWidth := origWidth
-	newHeight := origHeight
-
-	if origWidth > maxImageWidth || origHeight > maxImageHeight {
-		// Scale down proportionally
-		widthRatio := float64(maxImageWidth) / float64(origWidth)
-		heightRatio := float64(maxImageHeight) / float64(origHeight)
-		ratio := widthRatio
-		if heightRatio < widthRatio {
-			ratio = heightRatio
+	if adapter, pattern := adapterFor(parsedURL); adapter != nil {
+		article, err := adapter.Fetch(parsedURL)
+		if err == nil {
+			if len(article.Source) == 0 {
+				article.Source = pageURL
+			}
+			return article, nil
+		}
+		if !errors.Is(err, ErrSkipAdapter) {
+			util.Red.Printf("The %s adapter couldn't extract %s, using readability : %s\n", pattern, pageURL, err)
 		}
-		newWidth = int(float64(origWidth) * ratio)
-		newHeight = int(float64(origHeight) * ratio)
 	}
-
-	// Resize if needed
-	var finalImg image.Image
-	if newWidth != origWidth || newHeight != origHeight {
-		resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-		draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
-		finalImg = resized
-		util.Cyan.Printf("Resized image from %dx%d to %dx%d\n", origWidth, origHeight, newWidth, newHeight)
-	} else {
-		finalImg = img
+	if isTranscriptFile(parsedURL.Path) {
+		return fetchTranscript(pageURL, parsedURL)
 	}
+	return fetchWithReadability(pageURL, parsedURL)
+}
 
-	// Create temp file for the compressed image
-	tmpFile, err := os.CreateTemp("", "kindle-img-*.jpg")
+// fetchWithReadability fetches a page and extracts its article with
+// readability, following its next pages
+func fetchWithReadability(pageURL string, parsedURL *url.URL) (Article, error) {
+	// Keep the page around, readability drops the metadata we need for the header
+	page, _, err := fetchPage(pageURL)
 	if err != nil {
-		return "", err
+		return Article{}, err
 	}
-	defer tmpFile.Close()
 
-	// Encode as JPEG with compression
-	err = jpeg.Encode(tmpFile, finalImg, &jpeg.Options{Quality: jpegQuality})
+	readable, err := readability.FromReader(bytes.NewReader(page), parsedURL)
 	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", err
+		return Article{}, err
 	}
-
-	return tmpFile.Name(), nil
+	article := Article{
+		Article:   readable,
+		Source:    pageURL,
+		Published: publishedDate(page),
+	}
+	followPages(&article, page, parsedURL)
+	addTranscript(&article, page, parsedURL)
+	return article, nil
 }
 
-// Download images and add to epub zip
-func (e *epubmaker) downloadImages(i int, img *goquery.Selection) {
-	util.CyanBold.Println("Downloading Images")
-	imgSrc, exists := img.Attr("src")
+// body returns the body of a section as written for the output format
+func (e *epubmaker) body(content string) string {
+	if e.opts.Format == FormatKEPUB {
+		return kepubify(content)
+	}
+	return content
+}
 
-	if exists {
+// Add articles to epub, split at their headings when the options ask for
+// a deeper table of contents and with their links turned into endnotes
+func (e *epubmaker) addContent(articles *[]Article) error {
+	if err := checkEndnotes(e.opts.Endnotes); err != nil {
+		return err
+	}
+	var bookNotes *endnotes
+	if e.opts.Endnotes == EndnotesBook {
+		bookNotes = newEndnotes("", "links.xhtml")
+	}
 
-		//don't download same thing twice
-		if _, ok := e.downloads[imgSrc]; ok {
-			return
+	added := 0
+	for idx, article := range *articles {
+		// Sections are named so endnotes can link back to them
+		file := fmt.Sprintf("article%03d.xhtml", idx+1)
+		notes := bookNotes
+		if e.opts.Endnotes == EndnotesArticle {
+			notes = newEndnotes(fmt.Sprintf("a%d-", idx+1), fmt.Sprintf("article%03d-links.xhtml", idx+1))
 		}
 
-		//pass unique and safe image names here, then it will not crash on windows
-		//use murmur hash to generate file name
-		imageFileName := util.GetHash(imgSrc) + ".jpg"
-
-		// Download and compress the image
-		tmpPath, err := downloadAndCompressImage(imgSrc)
-		if err != nil {
-			util.Red.Printf("Couldn't download/compress image %s : %s\n", imgSrc, err)
-			return
+		intro, chapters := splitAtHeadings(article.Content, tocDepth(&article, e.opts))
+		files := []string{file}
+		if len(chapters) > 0 {
+			parts := []string{intro}
+			for i, chapter := range chapters {
+				parts = append(parts, chapter.Body)
+				files = append(files, fmt.Sprintf("article%03d-%02d.xhtml", idx+1, i+1))
+			}
+			// Anchors may now be in another file than the links to them
+			parts = linkSections(parts, files)
+			intro = parts[0]
+			for i := range chapters {
+				chapters[i].Body = parts[i+1]
+			}
 		}
-
-		// Get file size for logging before adding
-		var sizeKB int64
-		if fi, err := os.Stat(tmpPath); err == nil {
-			sizeKB = fi.Size() / 1024
+		if notes != nil {
+			intro = notes.rewrite(intro, file, article.Source)
 		}
-
-		// Add the compressed image to the epub using file:// URL scheme
-		imgRef, err := e.Epub.AddImage("file://"+tmpPath, imageFileName)
-
-		// Clean up temp file after adding
-		os.Remove(tmpPath)
-
+		parent, err := e.Epub.AddSection(e.body(articleHeader(&article)+intro), article.Title, file, e.css)
 		if err != nil {
-			util.Red.Printf("Couldn't add image %s : %s\n", imgSrc, err)
-			return
+			util.Red.Printf("Couldn't add %s to epub : %s", article.Title, err)
+			continue
+		}
+		added++
+		for i, chapter := range chapters {
+			chapterFile := files[i+1]
+			body := chapter.Body
+			if notes != nil {
+				body = notes.rewrite(body, chapterFile, article.Source)
+			}
+			if _, err := e.Epub.AddSubSection(parent, e.body(body), chapter.Title, chapterFile, e.css); err != nil {
+				util.Red.Printf("Couldn't add section %s of %s to epub : %s", chapter.Title, article.Title, err)
+			}
+		}
+		if e.opts.Endnotes == EndnotesArticle && !notes.empty() {
+			if _, err := e.Epub.AddSubSection(parent, e.body(notes.render()), endnotesTitle, notes.file, e.css); err != nil {
+				util.Red.Printf("Couldn't add links of %s to epub : %s", article.Title, err)
+			}
 		}
-
-		util.Green.Printf("Added image %s (compressed to %dKB)\n", filepath.Base(imgSrc), sizeKB)
-		e.downloads[imgSrc] = imgRef
-	}
-}
-
-// Fetches images in article and then embeds them into epub
-func (e *epubmaker) embedImages(wg *sync.WaitGroup, article *readability.Article) {
-	util.Cyan.Println("Embedding images in ", article.Title)
-	defer wg.Done()
-	//TODO: Compress images before embedding to improve size
-	doc := goquery.NewDocumentFromNode(article.Node)
-
-	//download all images
-	doc.Find("img").Each(e.downloadImages)
-
-	//Change all refs, doing it in two phases to download repeated images only once
-	doc.Find("img").Each(e.changeRefs)
-
-	content, err := doc.Html()
-
-	if err != nil {
-		util.Red.Printf("Error converting modified %s to HTML, it will be transferred without images : %s \n", article.Title, err)
-	} else {
-		article.Content = content
 	}
-}
-
-// TODO: Look for better formatting, this is bare bones
-func prepare(article *readability.Article) string {
-	return "<h1>" + article.Title + "</h1>" + article.Content
-}
-
-// Add articles to epub
-func (e *epubmaker) addContent(articles *[]readability.Article) error {
-	added := 0
-	for _, article := range *articles {
-		_, err := e.Epub.AddSection(prepare(&article), article.Title, "", "")
-		if err != nil {
-			util.Red.Printf("Couldn't add %s to epub : %s", article.Title, err)
-		} else {
-			added++
+	if bookNotes != nil && !bookNotes.empty() {
+		if _, err := e.Epub.AddSection(e.body(bookNotes.render()), endnotesTitle, bookNotes.file, e.css); err != nil {
+			util.Red.Printf("Couldn't add links to epub : %s", err)
 		}
 	}
 	util.Green.Printf("Added %d articles\n", added)
 	if added == 0 {
 		return errors.New("No article was added, epub creation failed")
 	}
+
+	if lang := bookLanguage(*articles); len(lang) > 0 {
+		e.Epub.SetLang(lang)
+	}
+	// A single article book is that article, describe it in the metadata too
+	if len(*articles) == 1 {
+		article := (*articles)[0]
+		if byline := strings.TrimSpace(article.Byline); len(byline) > 0 {
+			e.Epub.SetAuthor(byline)
+		}
+		if description := articleDescription(&article); len(description) > 0 {
+			e.Epub.SetDescription(description)
+		}
+	}
 	return nil
 }
 
@@ -280,18 +259,18 @@ type ManualArticle struct {
 }
 
 // Generates a single epub from a slice of urls, saves to specified directory, returns file path
-func MakeToDir(pageUrls []string, title string, outputDir string) (string, error) {
-	return makeEpubWithManual(pageUrls, nil, title, outputDir)
+func MakeToDir(pageUrls []string, title string, outputDir string, opts Options) (string, error) {
+	return makeEpubWithManual(pageUrls, nil, title, outputDir, opts)
 }
 
 // MakeToDirWithManual generates an epub from URLs and manual articles
-func MakeToDirWithManual(pageUrls []string, manualArticles []ManualArticle, title string, outputDir string) (string, error) {
-	return makeEpubWithManual(pageUrls, manualArticles, title, outputDir)
+func MakeToDirWithManual(pageUrls []string, manualArticles []ManualArticle, title string, outputDir string, opts Options) (string, error) {
+	return makeEpubWithManual(pageUrls, manualArticles, title, outputDir, opts)
 }
 
 // Generates a single epub from a slice of urls, returns file path
-func Make(pageUrls []string, title string) (string, error) {
-	return makeEpubWithManual(pageUrls, nil, title, "")
+func Make(pageUrls []string, title string, opts Options) (string, error) {
+	return makeEpubWithManual(pageUrls, nil, title, "", opts)
 }
 
 // formatManualContent converts content to HTML
@@ -321,40 +300,16 @@ func formatManualContent(content string) string {
 	return strings.Join(result, "\n")
 }
 
-// Internal function that handles epub generation with optional manual articles
-func makeEpubWithManual(pageUrls []string, manualArticles []ManualArticle, title string, outputDir string) (string, error) {
-	//TODO: Parallelize fetching pages
-
+// Internal function that builds a book in the output format of the options
+// from urls and optional manual articles
+func makeEpubWithManual(pageUrls []string, manualArticles []ManualArticle, title string, outputDir string, opts Options) (string, error) {
 	//Get readable article from urls
-	readableArticles := make([]readability.Article, 0)
-	for _, pageUrl := range pageUrls {
-		article, err := fetchReadable(pageUrl)
-		if err != nil {
-			util.Red.Printf("Couldn't convert %s because %s", pageUrl, err)
-			util.Magenta.Println("SKIPPING ", pageUrl)
-			continue
-		}
-		util.Green.Printf("Fetched %s --> %s\n", pageUrl, article.Title)
-		readableArticles = append(readableArticles, article)
-	}
+	readableArticles := fetchAll(pageUrls)
 
-	// Add manual articles (convert to readability.Article format)
+	// Add manual articles (convert to Article format)
 	for _, manual := range manualArticles {
 		// Format content (preserves HTML if present, otherwise converts plain text)
-		content := formatManualContent(manual.Content)
-
-		// Parse HTML to create a Node for image embedding
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + content + "</body>"))
-		var node *html.Node
-		if err == nil {
-			node = doc.Find("body").Get(0)
-		}
-
-		article := readability.Article{
-			Title:   manual.Title,
-			Content: content,
-			Node:    node,
-		}
+		article := NewArticle(manual.Title, "", formatManualContent(manual.Content), manual.Source)
 		util.Green.Printf("Added manual article: %s\n", manual.Title)
 		readableArticles = append(readableArticles, article)
 	}
@@ -362,56 +317,63 @@ func makeEpubWithManual(pageUrls []string, manualArticles []ManualArticle, title
 	if len(readableArticles) == 0 {
 		return "", errors.New("No readable url or manual article given, exiting without creating epub")
 	}
+	return makeBook(readableArticles, title, outputDir, opts)
+}
 
+// makeBook builds a book from articles, embedding their images, and saves it
+// to outputDir or where books are stored
+func makeBook(readableArticles []Article, title string, outputDir string, opts Options) (string, error) {
 	if len(title) == 0 {
 		title = readableArticles[0].Title
 		util.Magenta.Printf("No title supplied, inheriting title of first readable article : %s \n", title)
 	}
 
-	book := NewEpubmaker(title)
-
-	//get images and embed them (only for articles with parsed HTML nodes)
-	var wg sync.WaitGroup
-
-	for i := 0; i < len(readableArticles); i++ {
-		// Skip image embedding for manual articles (no parsed Node)
-		if readableArticles[i].Node == nil {
-			continue
-		}
-		wg.Add(1)
-		go book.embedImages(&wg, &readableArticles[i])
+	book, err := newBookWriter(title, opts)
+	if err != nil {
+		return "", err
 	}
 
-	wg.Wait()
+	//get images and embed them (only for articles with parsed HTML nodes)
+	newImageStore(book, opts.Profile).embedImages(readableArticles)
 
-	err := book.addContent(&readableArticles)
+	err = book.addContent(&readableArticles)
 	if err != nil {
 		return "", err
 	}
-	var storeDir string
-	if len(outputDir) > 0 {
-		storeDir = outputDir
-	} else if config.GetInstance() != nil && len(config.GetInstance().StorePath) > 0 {
-		storeDir = config.GetInstance().StorePath
-	} else {
-		storeDir, err = os.Getwd()
-		if err != nil {
-			util.Red.Println("Error getting current directory, trying fallback")
-			storeDir = "./"
+	// Markdown notes have no cover
+	if opts.Format != FormatMarkdown {
+		if err := addCover(book, title, readableArticles, opts); err != nil {
+			util.Red.Println("Couldn't add cover, the book will be created without one :", err)
 		}
 	}
-
 	titleSlug := slug.Make(title)
 	var filename string
 	if len(titleSlug) == 0 {
-		filename = "kindle-send-doc-" + util.GetHash(readableArticles[0].Content) + ".epub"
+		filename = "kindle-send-doc-" + util.GetHash(readableArticles[0].Content) + fileExtension(opts.Format)
 	} else {
-		filename = titleSlug + ".epub"
+		filename = titleSlug + fileExtension(opts.Format)
 	}
-	filepath := path.Join(storeDir, filename)
-	err = book.Epub.Write(filepath)
+	filepath := path.Join(storeDir(outputDir), filename)
+	err = book.write(filepath)
 	if err != nil {
 		return "", err
 	}
 	return filepath, nil
 }
+
+// storeDir returns where files are saved, outputDir if given, else the store
+// path of the config or the current directory
+func storeDir(outputDir string) string {
+	if len(outputDir) > 0 {
+		return outputDir
+	}
+	if config.GetInstance() != nil && len(config.GetInstance().StorePath) > 0 {
+		return config.GetInstance().StorePath
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		util.Red.Println("Error getting current directory, trying fallback")
+		return "./"
+	}
+	return dir
+}
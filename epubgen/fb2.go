@@ -0,0 +1,535 @@
+package epubgen
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// fb2Binary is an image stored at the end of a FictionBook file
+type fb2Binary struct {
+	id        string
+	mediaType string
+	data      []byte
+}
+
+// fb2Footnote is a footnote of an article, like a citation of a Wikipedia
+// article, written to the notes body so readers show it where it's cited
+type fb2Footnote struct {
+	id    string
+	title string
+	body  string // FictionBook content of the note
+}
+
+// Links to the sections of the notes body: endnotes, and footnotes
+var fb2NoteLink = regexp.MustCompile(`^#(a\d+-)?(note|fn)-\d+$`)
+
+// fb2Writer writes a book as FictionBook 2
+type fb2Writer struct {
+	title     string
+	opts      Options
+	cover     string // Id of the cover binary
+	binaries  []fb2Binary
+	body      strings.Builder
+	notes     []*endnotes
+	footnotes []fb2Footnote
+	lang      string
+	// Metadata of single article books
+	author     string
+	annotation string
+}
+
+func newFB2Writer(title string, opts Options) *fb2Writer {
+	return &fb2Writer{title: title, opts: opts}
+}
+
+func (w *fb2Writer) addImage(name string, img processedImage) (string, error) {
+	w.binaries = append(w.binaries, fb2Binary{id: name, mediaType: img.mediaType, data: img.data})
+	return "#" + name, nil
+}
+
+func (w *fb2Writer) setCover(name string, img processedImage) error {
+	if _, err := w.addImage(name, img); err != nil {
+		return err
+	}
+	w.cover = name
+	return nil
+}
+
+// fb2Text escapes text for FictionBook, dropping characters XML can't hold
+func fb2Text(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, text)
+	return htmlutil.EscapeString(text)
+}
+
+func fb2Title(title string) string {
+	return "<title><p>" + fb2Text(title) + "</p></title>"
+}
+
+// fb2Header is the FictionBook version of the article header
+func fb2Header(article *Article) string {
+	var header strings.Builder
+	if author := byline(article); len(author) > 0 {
+		header.WriteString("<p><strong>" + fb2Text(author) + "</strong></p>")
+	}
+	header.WriteString("<p><emphasis>" + fb2Text(articleDetails(article)) + "</emphasis></p>")
+	if len(article.Source) > 0 {
+		source := fb2Text(article.Source)
+		header.WriteString(`<p><a l:href="` + source + `">` + source + "</a></p>")
+	}
+	header.WriteString("<empty-line/>")
+	return header.String()
+}
+
+// fb2Section wraps content in a section, sections can't be empty
+func fb2Section(title string, content string) string {
+	if len(content) == 0 {
+		content = "<empty-line/>"
+	}
+	section := "<section>"
+	if len(title) > 0 {
+		section += fb2Title(title)
+	}
+	return section + content + "</section>"
+}
+
+func (w *fb2Writer) addContent(articles *[]Article) error {
+	if err := checkEndnotes(w.opts.Endnotes); err != nil {
+		return err
+	}
+	if len(*articles) == 0 {
+		return errors.New("No article was added, fb2 creation failed")
+	}
+	var bookNotes *endnotes
+	if w.opts.Endnotes == EndnotesBook {
+		bookNotes = newEndnotes("", "")
+		w.notes = append(w.notes, bookNotes)
+	}
+
+	for idx, article := range *articles {
+		notes := bookNotes
+		if w.opts.Endnotes == EndnotesArticle {
+			notes = newEndnotes(fmt.Sprintf("a%d-", idx+1), "")
+			w.notes = append(w.notes, notes)
+		}
+		rewrite := func(content string) string {
+			if notes == nil {
+				return content
+			}
+			return notes.rewrite(content, "", article.Source)
+		}
+
+		// A section holds either text or other sections, never both
+		content := w.addFootnotes(article.Content, fmt.Sprintf("a%d-", idx+1))
+		intro, chapters := splitAtHeadings(content, tocDepth(&article, w.opts))
+		introText := fb2Header(&article) + htmlToFB2(rewrite(intro), false)
+		if len(chapters) == 0 {
+			w.body.WriteString(fb2Section(article.Title, introText))
+			continue
+		}
+		w.body.WriteString("<section>" + fb2Title(article.Title) + fb2Section("", introText))
+		for _, chapter := range chapters {
+			w.body.WriteString(fb2Section(chapter.Title, htmlToFB2(rewrite(chapter.Body), true)))
+		}
+		w.body.WriteString("</section>")
+	}
+	util.Green.Printf("Added %d articles\n", len(*articles))
+
+	w.lang = bookLanguage(*articles)
+	if len(*articles) == 1 {
+		article := (*articles)[0]
+		w.author = strings.TrimSpace(article.Byline)
+		w.annotation = articleDescription(&article)
+	}
+	return nil
+}
+
+// addFootnotes moves the list items that superscript links of an article
+// point at, like the references of Wikipedia citations, to the footnotes of
+// the book and points the links at them. The ids of the notes start with
+// prefix. Citations without a note in the article stay plain text.
+func (w *fb2Writer) addFootnotes(content string, prefix string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+	targets := make(map[string]*goquery.Selection)
+	doc.Find("li[id]").Each(func(_ int, li *goquery.Selection) {
+		targets[li.AttrOr("id", "")] = li
+	})
+	ids := make(map[string]string)
+	doc.Find(`sup a[href^="#"]`).Each(func(_ int, a *goquery.Selection) {
+		target := strings.TrimPrefix(a.AttrOr("href", ""), "#")
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		li, ok := targets[target]
+		if !ok {
+			return
+		}
+		id, seen := ids[target]
+		if !seen {
+			id = fmt.Sprintf("%sfn-%d", prefix, len(ids)+1)
+			ids[target] = id
+			note := li.Clone()
+			// Links back to the citations, with the elements only holding them
+			note.Find(`a[href^="#"]`).Each(func(_ int, back *goquery.Selection) {
+				text := strings.TrimSpace(back.Text())
+				for !back.Parent().Is("li") && strings.TrimSpace(back.Parent().Text()) == text {
+					back = back.Parent()
+				}
+				back.Remove()
+			})
+			inner, _ := note.Html()
+			title := strings.Trim(strings.TrimSpace(a.Text()), "[]")
+			if len(title) == 0 {
+				title = fmt.Sprint(len(ids))
+			}
+			w.footnotes = append(w.footnotes, fb2Footnote{id: id, title: title, body: htmlToFB2(inner, false)})
+		}
+		a.SetAttr("href", "#"+id)
+	})
+	if len(ids) == 0 {
+		return content
+	}
+	out, err := doc.Find("body").Html()
+	if err != nil {
+		return content
+	}
+	return out
+}
+
+func (w *fb2Writer) write(filepath string) error {
+	now := time.Now()
+	author := w.author
+	if len(author) == 0 {
+		author = "kindle-send"
+	}
+	date := `<date value="` + now.Format("2006-01-02") + `">` + now.Format("2 January 2006") + "</date>"
+
+	var out strings.Builder
+	out.WriteString(xml.Header)
+	out.WriteString(`<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">`)
+
+	out.WriteString("<description><title-info><genre>nonfiction</genre>")
+	out.WriteString("<author><nickname>" + fb2Text(author) + "</nickname></author>")
+	out.WriteString("<book-title>" + fb2Text(w.title) + "</book-title>")
+	if len(w.annotation) > 0 {
+		out.WriteString("<annotation>")
+		for _, line := range strings.Split(w.annotation, "\n") {
+			out.WriteString("<p>" + fb2Text(line) + "</p>")
+		}
+		out.WriteString("</annotation>")
+	}
+	out.WriteString(date)
+	if len(w.cover) > 0 {
+		out.WriteString(`<coverpage><image l:href="#` + w.cover + `"/></coverpage>`)
+	}
+	lang := "en"
+	if len(w.lang) > 0 {
+		lang = w.lang
+	}
+	out.WriteString("<lang>" + fb2Text(lang) + "</lang></title-info>")
+	out.WriteString("<document-info><author><nickname>kindle-send</nickname></author><program-used>kindle-send</program-used>" + date)
+	out.WriteString("<id>" + util.GetHash(w.title+now.String()) + "</id><version>1.0</version></document-info>")
+	out.WriteString("</description>")
+
+	out.WriteString("<body>" + fb2Title(w.title) + w.body.String() + "</body>")
+
+	var notes strings.Builder
+	for _, n := range w.notes {
+		for idx, note := range n.notes {
+			link := fb2Text(note.URL)
+			notes.WriteString(`<section id="` + n.noteID(idx+1) + `">` + fb2Title(fmt.Sprint(idx+1)))
+			notes.WriteString(`<p><a l:href="` + link + `">` + link + "</a></p></section>")
+		}
+	}
+	for _, note := range w.footnotes {
+		body := note.body
+		if len(body) == 0 {
+			body = "<empty-line/>"
+		}
+		notes.WriteString(`<section id="` + note.id + `">` + fb2Title(note.title) + body + "</section>")
+	}
+	if notes.Len() > 0 {
+		title := endnotesTitle
+		if len(w.footnotes) > 0 {
+			title = "Notes"
+		}
+		out.WriteString(`<body name="notes">` + fb2Title(title) + notes.String() + "</body>")
+	}
+
+	for _, binary := range w.binaries {
+		out.WriteString(`<binary id="` + binary.id + `" content-type="` + binary.mediaType + `">`)
+		out.WriteString(base64.StdEncoding.EncodeToString(binary.data))
+		out.WriteString("</binary>")
+	}
+	out.WriteString("</FictionBook>\n")
+
+	return os.WriteFile(filepath, []byte(out.String()), 0644)
+}
+
+// Inline HTML elements and the FictionBook element they become, empty if
+// only their text is kept
+var fb2Inline = map[atom.Atom]string{
+	atom.Strong: "strong", atom.B: "strong",
+	atom.Em: "emphasis", atom.I: "emphasis", atom.Cite: "emphasis", atom.Dfn: "emphasis",
+	atom.Code: "code", atom.Kbd: "code", atom.Samp: "code", atom.Tt: "code", atom.Var: "code",
+	atom.Sub: "sub", atom.Sup: "sup",
+	atom.S: "strikethrough", atom.Strike: "strikethrough", atom.Del: "strikethrough",
+	atom.A: "", atom.Abbr: "", atom.Span: "", atom.Small: "", atom.U: "", atom.Mark: "",
+	atom.Q: "", atom.Time: "", atom.Data: "", atom.Ins: "", atom.Font: "", atom.Label: "",
+	atom.Bdi: "", atom.Bdo: "",
+}
+
+// Elements that are dropped with their content
+var fb2Skip = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Svg: true,
+	atom.Iframe: true, atom.Button: true, atom.Form: true, atom.Input: true,
+}
+
+// fb2Converter turns article HTML into FictionBook section content
+type fb2Converter struct {
+	out    strings.Builder
+	inPara bool
+	inCite bool
+	inLink bool
+}
+
+// htmlToFB2 converts an HTML fragment to FictionBook paragraphs, subtitles,
+// quotes and images. skipHeading drops a leading heading that is already
+// the title of the section.
+func htmlToFB2(content string, skipHeading bool) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	body := doc.Find("body").Get(0)
+	if body == nil {
+		return ""
+	}
+	if skipHeading {
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				if headingLevel(c) > 0 {
+					body.RemoveChild(c)
+				}
+				break
+			}
+		}
+	}
+	c := &fb2Converter{}
+	c.blocks(body)
+	c.closePara()
+	return c.out.String()
+}
+
+func (c *fb2Converter) openPara() {
+	if !c.inPara {
+		c.out.WriteString("<p>")
+		c.inPara = true
+	}
+}
+
+func (c *fb2Converter) closePara() {
+	if c.inPara {
+		c.out.WriteString("</p>")
+		c.inPara = false
+	}
+}
+
+func hasImage(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if (c.Type == html.ElementNode && c.DataAtom == atom.Img) || hasImage(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseSpace turns runs of white space into a single space
+func collapseSpace(text string) string {
+	var out strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			if !space {
+				out.WriteRune(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// blocks converts the children of a block element
+func (c *fb2Converter) blocks(n *html.Node) {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		switch {
+		case ch.Type == html.TextNode:
+			if c.inPara || len(strings.TrimSpace(ch.Data)) > 0 {
+				c.openPara()
+				c.out.WriteString(fb2Text(collapseSpace(ch.Data)))
+			}
+		case ch.Type != html.ElementNode || fb2Skip[ch.DataAtom]:
+		case ch.DataAtom == atom.Img:
+			c.image(ch)
+		case ch.DataAtom == atom.Br:
+			c.closePara()
+		default:
+			if _, inline := fb2Inline[ch.DataAtom]; inline && !hasImage(ch) {
+				c.openPara()
+				c.inline(ch)
+			} else {
+				c.block(ch)
+			}
+		}
+	}
+}
+
+func (c *fb2Converter) image(n *html.Node) {
+	c.closePara()
+	for _, attr := range n.Attr {
+		// Only stored images can be shown, quotes can't hold images
+		if attr.Key == "src" && strings.HasPrefix(attr.Val, "#") && !c.inCite {
+			c.out.WriteString(`<image l:href="` + fb2Text(attr.Val) + `"/>`)
+		}
+	}
+}
+
+// nodeText returns the text of a node with collapsed white space
+func nodeText(n *html.Node) string {
+	return collapseSpace(goquery.NewDocumentFromNode(n).Text())
+}
+
+func (c *fb2Converter) block(n *html.Node) {
+	c.closePara()
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if title := strings.TrimSpace(nodeText(n)); len(title) > 0 {
+			c.out.WriteString("<subtitle>" + fb2Text(title) + "</subtitle>")
+		}
+	case atom.Ul, atom.Ol:
+		num := 0
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+				continue
+			}
+			num++
+			c.openPara()
+			if n.DataAtom == atom.Ol {
+				c.out.WriteString(fmt.Sprintf("%d. ", num))
+			} else {
+				c.out.WriteString("• ")
+			}
+			c.blocks(li)
+			c.closePara()
+		}
+	case atom.Blockquote:
+		if c.inCite {
+			c.blocks(n)
+			break
+		}
+		c.out.WriteString("<cite>")
+		start := c.out.Len()
+		c.inCite = true
+		c.blocks(n)
+		c.closePara()
+		c.inCite = false
+		if c.out.Len() == start {
+			c.out.WriteString("<empty-line/>")
+		}
+		c.out.WriteString("</cite>")
+	case atom.Pre:
+		for _, line := range strings.Split(strings.TrimRight(goquery.NewDocumentFromNode(n).Text(), "\n"), "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				c.out.WriteString("<empty-line/>")
+			} else {
+				c.out.WriteString("<p><code>" + fb2Text(line) + "</code></p>")
+			}
+		}
+	case atom.Hr:
+		c.out.WriteString("<empty-line/>")
+	case atom.Tr:
+		var cells []string
+		for td := n.FirstChild; td != nil; td = td.NextSibling {
+			if td.Type == html.ElementNode {
+				cells = append(cells, strings.TrimSpace(nodeText(td)))
+			}
+		}
+		c.out.WriteString("<p>" + fb2Text(strings.Join(cells, " | ")) + "</p>")
+	default:
+		c.blocks(n)
+	}
+	c.closePara()
+}
+
+// inline converts an inline element, it is inside an open paragraph
+func (c *fb2Converter) inline(n *html.Node) {
+	tag := fb2Inline[n.DataAtom]
+	open, closing := "", ""
+	if len(tag) > 0 {
+		open, closing = "<"+tag+">", "</"+tag+">"
+	}
+	if n.DataAtom == atom.A && !c.inLink {
+		for _, attr := range n.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			href := strings.TrimSpace(attr.Val)
+			switch {
+			case fb2NoteLink.MatchString(href):
+				open, closing = `<a l:href="`+fb2Text(href)+`" type="note">`, "</a>"
+			case strings.HasPrefix(href, "http://"), strings.HasPrefix(href, "https://"), strings.HasPrefix(href, "mailto:"):
+				open, closing = `<a l:href="`+fb2Text(href)+`">`, "</a>"
+			}
+		}
+	}
+	link := strings.HasPrefix(open, "<a ")
+	if link {
+		c.inLink = true
+	}
+
+	c.out.WriteString(open)
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		switch {
+		case ch.Type == html.TextNode:
+			c.out.WriteString(fb2Text(collapseSpace(ch.Data)))
+		case ch.Type != html.ElementNode || fb2Skip[ch.DataAtom]:
+		case ch.DataAtom == atom.Br:
+			c.out.WriteString(" ")
+		default:
+			if _, inline := fb2Inline[ch.DataAtom]; inline {
+				c.inline(ch)
+			} else {
+				c.out.WriteString(fb2Text(nodeText(ch)))
+			}
+		}
+	}
+	c.out.WriteString(closing)
+
+	if link {
+		c.inLink = false
+	}
+}
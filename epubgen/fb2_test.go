@@ -0,0 +1,44 @@
+package epubgen
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHTMLToFB2IsWellFormed(t *testing.T) {
+	content := `<h2>Title</h2><div><p>Some <b>bold <i>and italic</i></b> text<br>with a <a href="https://example.com/?a=1&b=2">link</a></p>
+<ul><li>one</li><li>two <img src="#img1.png"></li></ul>
+<blockquote>quoted <img src="#img2.png"></blockquote>
+<pre>line one
+line &lt;two&gt;</pre><table><tr><td>a</td><td>b</td></tr></table></div>`
+
+	out := htmlToFB2(content, true)
+
+	doc := `<section xmlns:l="http://www.w3.org/1999/xlink">` + out + `</section>`
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("invalid FictionBook %s : %s", out, err)
+		}
+	}
+	for _, want := range []string{
+		"<p>Some <strong>bold <emphasis>and italic</emphasis></strong> text</p>",
+		`<a l:href="https://example.com/?a=1&amp;b=2">link</a>`,
+		`<p>• two </p><image l:href="#img1.png"/>`,
+		"<cite><p>quoted </p></cite>",
+		"<p><code>line &lt;two&gt;</code></p>",
+		"<p>a | b</p>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+	if strings.Contains(out, "<subtitle>Title</subtitle>") {
+		t.Errorf("leading heading should be skipped: %s", out)
+	}
+}
@@ -0,0 +1,367 @@
+package epubgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// Feed items with at least this many words of content are taken as full
+// text, shorter ones as summaries of an article to fetch
+const fullTextWords = 150
+
+// Feed is an RSS, Atom or JSON feed
+type Feed struct {
+	Title string
+	Link  string
+	Items []FeedItem
+}
+
+// FeedItem is an entry of a feed
+type FeedItem struct {
+	ID        string // Guid or id of the item, its link when it has none
+	Title     string
+	Link      string
+	Author    string
+	Published time.Time
+	Content   string // Html of the item, the full text or a summary
+	Feed      string // Title of the feed it comes from
+	// Fetch the article even when the item has its full text
+	FetchArticle bool
+}
+
+// Layouts of RSS dates parseDate doesn't know
+var feedDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+func feedDate(value string) time.Time {
+	if date := parseDate(value); !date.IsZero() {
+		return date
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// rssItem is an item of RSS 2.0 and RSS 1.0 feeds
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Author      string `xml:"author"`
+	Description string `xml:"description"`
+	Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+// rssFeed is an RSS 2.0 feed, or an RSS 1.0 one where items come after the
+// channel instead of inside it
+type rssFeed struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Link  string    `xml:"link"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// html returns the text as html, xhtml content is markup already
+func (t atomText) html() string {
+	switch t.Type {
+	case "xhtml":
+		return strings.TrimSpace(t.Inner)
+	case "html":
+		return strings.TrimSpace(t.Text)
+	}
+	if text := strings.TrimSpace(t.Text); len(text) > 0 {
+		return "<p>" + htmlutil.EscapeString(text) + "</p>"
+	}
+	return ""
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomFeed struct {
+	Title   string     `xml:"title"`
+	Links   []atomLink `xml:"link"`
+	Entries []struct {
+		ID        string     `xml:"id"`
+		Title     atomText   `xml:"title"`
+		Links     []atomLink `xml:"link"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Authors   []struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+		Summary atomText `xml:"summary"`
+		Content atomText `xml:"content"`
+	} `xml:"entry"`
+}
+
+// alternate is the link to the page of a feed or entry
+func alternate(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
+}
+
+type jsonFeed struct {
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	Items       []struct {
+		ID            any    `json:"id"`
+		URL           string `json:"url"`
+		Title         string `json:"title"`
+		ContentHTML   string `json:"content_html"`
+		ContentText   string `json:"content_text"`
+		Summary       string `json:"summary"`
+		DatePublished string `json:"date_published"`
+		Author        struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		Authors []struct {
+			Name string `json:"name"`
+		} `json:"authors"`
+	} `json:"items"`
+}
+
+// FetchFeed fetches and reads an RSS, Atom or JSON feed
+func FetchFeed(feedURL string) (Feed, error) {
+	data, status, err := fetchPage(feedURL)
+	if err != nil {
+		return Feed{}, err
+	}
+	if status != http.StatusOK {
+		return Feed{}, errors.New(http.StatusText(status))
+	}
+	return parseFeed(data)
+}
+
+// parseFeed reads a feed in any of the formats, items keep their order
+func parseFeed(data []byte) (Feed, error) {
+	var feed Feed
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("{")) {
+		var jf jsonFeed
+		if err := json.Unmarshal(trimmed, &jf); err != nil {
+			return Feed{}, fmt.Errorf("json feed: %w", err)
+		}
+		feed = Feed{Title: jf.Title, Link: jf.HomePageURL}
+		for _, item := range jf.Items {
+			author := item.Author.Name
+			if len(item.Authors) > 0 {
+				author = item.Authors[0].Name
+			}
+			content := item.ContentHTML
+			if len(content) == 0 && len(item.ContentText) > 0 {
+				content = "<p>" + strings.ReplaceAll(htmlutil.EscapeString(item.ContentText), "\n\n", "</p><p>") + "</p>"
+			}
+			if len(content) == 0 && len(item.Summary) > 0 {
+				content = "<p>" + htmlutil.EscapeString(item.Summary) + "</p>"
+			}
+			id := ""
+			if item.ID != nil {
+				id = fmt.Sprint(item.ID)
+			}
+			feed.Items = append(feed.Items, FeedItem{ID: id, Title: item.Title, Link: item.URL, Author: author,
+				Published: feedDate(item.DatePublished), Content: content})
+		}
+	} else {
+		root, err := xmlRoot(data)
+		if err != nil {
+			return Feed{}, err
+		}
+		switch root {
+		case "rss", "RDF":
+			var rf rssFeed
+			if err := xml.Unmarshal(data, &rf); err != nil {
+				return Feed{}, fmt.Errorf("rss feed: %w", err)
+			}
+			feed = Feed{Title: rf.Channel.Title, Link: strings.TrimSpace(rf.Channel.Link)}
+			for _, item := range append(rf.Channel.Items, rf.Items...) {
+				content := item.Encoded
+				if len(strings.TrimSpace(content)) == 0 {
+					content = item.Description
+				}
+				author := item.Creator
+				if len(author) == 0 {
+					author = item.Author
+				}
+				date := item.PubDate
+				if len(date) == 0 {
+					date = item.Date
+				}
+				feed.Items = append(feed.Items, FeedItem{ID: strings.TrimSpace(item.GUID), Title: item.Title, Link: strings.TrimSpace(item.Link),
+					Author: author, Published: feedDate(date), Content: strings.TrimSpace(content)})
+			}
+		case "feed":
+			var af atomFeed
+			if err := xml.Unmarshal(data, &af); err != nil {
+				return Feed{}, fmt.Errorf("atom feed: %w", err)
+			}
+			feed = Feed{Title: af.Title, Link: alternate(af.Links)}
+			for _, entry := range af.Entries {
+				content := entry.Content.html()
+				if len(content) == 0 {
+					content = entry.Summary.html()
+				}
+				date := entry.Published
+				if len(date) == 0 {
+					date = entry.Updated
+				}
+				var authors []string
+				for _, author := range entry.Authors {
+					authors = append(authors, author.Name)
+				}
+				feed.Items = append(feed.Items, FeedItem{ID: strings.TrimSpace(entry.ID), Title: strings.TrimSpace(entry.Title.Text),
+					Link: alternate(entry.Links), Author: strings.Join(authors, ", "), Published: feedDate(date), Content: content})
+			}
+		default:
+			return Feed{}, fmt.Errorf("not a feed, the document is a %s", root)
+		}
+	}
+
+	feed.Title = strings.TrimSpace(feed.Title)
+	for i := range feed.Items {
+		item := &feed.Items[i]
+		item.Feed = feed.Title
+		item.Title = strings.TrimSpace(item.Title)
+		if len(item.ID) == 0 {
+			item.ID = item.Link
+		}
+		if len(item.ID) == 0 {
+			item.ID = item.Title + " " + item.Published.Format(time.RFC3339)
+		}
+	}
+	return feed, nil
+}
+
+// xmlRoot returns the name of the root element of an xml document
+func xmlRoot(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("not a feed: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// HasFullText reports whether an item carries its whole article rather than
+// a summary
+func (item FeedItem) HasFullText() bool {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(item.Content))
+	if err != nil {
+		return false
+	}
+	return len(strings.Fields(doc.Text())) >= fullTextWords
+}
+
+// feedArticle makes an article of an item's own content, with its links and
+// images made absolute
+func feedArticle(item FeedItem) Article {
+	content := item.Content
+	if base, err := url.Parse(item.Link); err == nil && base.IsAbs() {
+		if doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + content + "</body>")); err == nil {
+			doc.Find("script, iframe").Remove()
+			doc.Find("a[href], img[src]").Each(func(_ int, s *goquery.Selection) {
+				attr := "href"
+				if goquery.NodeName(s) == "img" {
+					attr = "src"
+				}
+				if link, err := base.Parse(s.AttrOr(attr, "")); err == nil {
+					s.SetAttr(attr, link.String())
+				}
+			})
+			if body, err := doc.Find("body").Html(); err == nil {
+				content = body
+			}
+		}
+	}
+	article := NewArticle(item.Title, item.Author, content, item.Link)
+	article.Published = item.Published
+	article.SiteName = item.Feed
+	return article
+}
+
+// MakeDigest builds a book of feed items in the order given. Items with
+// their full text are used as they are, the articles of the others are
+// fetched, falling back to the summary of the feed.
+func MakeDigest(items []FeedItem, title string, outputDir string, opts Options) (string, error) {
+	var links []string
+	for _, item := range items {
+		if len(item.Link) > 0 && (item.FetchArticle || !item.HasFullText()) {
+			links = append(links, item.Link)
+		}
+	}
+	// Adapters can change the source of an article, so they are kept by link
+	var mu sync.Mutex
+	fetched := make(map[string]Article)
+	fetchAllWith(links, func(link string) (Article, error) {
+		article, err := fetchReadable(link)
+		if err == nil {
+			mu.Lock()
+			fetched[link] = article
+			mu.Unlock()
+		}
+		return article, err
+	})
+
+	var articles []Article
+	for _, item := range items {
+		article, ok := fetched[item.Link]
+		if !ok {
+			if len(strings.TrimSpace(item.Content)) == 0 {
+				util.Magenta.Println("SKIPPING ", item.Title)
+				continue
+			}
+			article = feedArticle(item)
+		}
+		if article.Published.IsZero() {
+			article.Published = item.Published
+		}
+		if len(article.SiteName) == 0 {
+			article.SiteName = item.Feed
+		}
+		articles = append(articles, article)
+	}
+	if len(articles) == 0 {
+		return "", errors.New("none of the feed items could be read")
+	}
+	return makeBook(articles, title, outputDir, opts)
+}
@@ -0,0 +1,98 @@
+package epubgen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const rssFeedXML = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel><title>Engine Notes</title><link>https://example.com/</link>
+<item><title>Second</title><link>https://example.com/second</link><guid>post-2</guid>
+<pubDate>Tue, 5 Mar 2024 10:00:00 +0000</pubDate><dc:creator>Ada</dc:creator>
+<description>A summary</description><content:encoded><![CDATA[<p>Full text with <img src="/engine.png"/></p>]]></content:encoded></item>
+<item><title>First</title><link>https://example.com/first</link><pubDate>Mon, 04 Mar 2024 10:00:00 +0000</pubDate>
+<description>&lt;p&gt;Only a summary&lt;/p&gt;</description></item>
+</channel></rss>`
+
+const atomFeedXML = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom Notes</title><link href="https://example.org/"/>
+<entry><id>tag:example.org,2024:1</id><title type="html">Engines &amp;amp; gears</title>
+<link rel="alternate" href="https://example.org/engines"/><updated>2024-03-05T10:00:00Z</updated>
+<author><name>Charles</name></author>
+<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Gears turn.</p></div></content></entry>
+</feed>`
+
+const jsonFeedText = `{"version":"https://jsonfeed.org/version/1.1","title":"JSON Notes","items":[
+{"id":1,"url":"https://example.net/one","title":"One","content_text":"Hello\n\nWorld","date_published":"2024-03-05T10:00:00Z","authors":[{"name":"Grace"}]}]}`
+
+func TestParseFeed(t *testing.T) {
+	feed, err := parseFeed([]byte(rssFeedXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Engine Notes" || len(feed.Items) != 2 {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+	second, first := feed.Items[0], feed.Items[1]
+	if second.ID != "post-2" || second.Author != "Ada" || second.Published.Day() != 5 || !strings.Contains(second.Content, "Full text") || second.Feed != "Engine Notes" {
+		t.Errorf("unexpected item %+v", second)
+	}
+	if first.ID != "https://example.com/first" || first.Content != "<p>Only a summary</p>" || first.Published.Day() != 4 {
+		t.Errorf("unexpected item %+v", first)
+	}
+
+	feed, err = parseFeed([]byte(atomFeedXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := feed.Items[0]
+	if feed.Title != "Atom Notes" || entry.Title != "Engines &amp; gears" || entry.Link != "https://example.org/engines" ||
+		entry.Author != "Charles" || !strings.Contains(entry.Content, "<p>Gears turn.</p>") || entry.Published.IsZero() {
+		t.Errorf("unexpected atom feed %+v", feed)
+	}
+
+	feed, err = parseFeed([]byte(jsonFeedText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := feed.Items[0]; item.ID != "1" || item.Author != "Grace" || item.Content != "<p>Hello</p><p>World</p>" {
+		t.Errorf("unexpected json feed %+v", feed)
+	}
+
+	if _, err := parseFeed([]byte(`<html><body>Not a feed</body></html>`)); err == nil {
+		t.Error("expected an error for an html page")
+	}
+}
+
+func TestMakeDigest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Summarised</title></head><body><article><h1>Summarised</h1>
+<p>` + strings.Repeat("The whole article fetched from the site. ", 30) + `</p></article></body></html>`))
+	}))
+	defer server.Close()
+
+	full := FeedItem{ID: "1", Title: "Full", Link: "https://example.com/posts/full", Feed: "Notes",
+		Content: `<p>` + strings.Repeat("word ", fullTextWords) + `<a href="../other">other</a></p>`}
+	summary := FeedItem{ID: "2", Title: "Summarised", Link: server.URL + "/summarised", Feed: "Notes", Content: "<p>Short</p>"}
+	if !full.HasFullText() || summary.HasFullText() {
+		t.Fatal("expected only the first item to have its full text")
+	}
+	dir := t.TempDir()
+	path, err := MakeDigest([]FeedItem{full, summary}, "Digest", dir, Options{Format: FormatHTML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := string(data)
+	if !strings.Contains(book, `href="https://example.com/other"`) || !strings.Contains(book, "The whole article fetched") ||
+		strings.Index(book, "word word") > strings.Index(book, "The whole article fetched") {
+		t.Errorf("unexpected digest %s", book)
+	}
+}
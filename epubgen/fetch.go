@@ -0,0 +1,119 @@
+package epubgen
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// Default limits for fetching pages in parallel
+const (
+	DefaultFetchLimit   = 6 // Pages fetched at the same time
+	DefaultPerHostLimit = 2 // Pages fetched at the same time from one host
+)
+
+var (
+	fetchLimit   = DefaultFetchLimit
+	perHostLimit = DefaultPerHostLimit
+)
+
+// SetFetchLimits sets how many pages are fetched in parallel overall and
+// per host. A value below 1 keeps the current limit.
+func SetFetchLimits(total int, perHost int) {
+	if total > 0 {
+		fetchLimit = total
+	}
+	if perHost > 0 {
+		perHostLimit = perHost
+	}
+}
+
+// hostLimiter bounds the number of requests in flight to a single host
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+func (h *hostLimiter) slot(host string) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.slots[host]; !ok {
+		h.slots[host] = make(chan struct{}, h.limit)
+	}
+	return h.slots[host]
+}
+
+func (h *hostLimiter) acquire(host string) {
+	h.slot(host) <- struct{}{}
+}
+
+func (h *hostLimiter) release(host string) {
+	<-h.slot(host)
+}
+
+// hostOf returns the lowercased host of a url, or the url itself if it can't be parsed
+func hostOf(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || len(u.Hostname()) == 0 {
+		return pageURL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// fetchAll fetches the readable version of every url in parallel, respecting
+// the global and per host limits. Articles are returned in the same order as
+// the urls, the ones that couldn't be fetched are skipped.
+func fetchAll(pageUrls []string) []Article {
+	return fetchAllWith(pageUrls, fetchReadable)
+}
+
+// fetchAllWith is fetchAll getting the articles with fetch
+func fetchAllWith(pageUrls []string, fetch func(pageUrl string) (Article, error)) []Article {
+	results := make([]*Article, len(pageUrls))
+
+	global := make(chan struct{}, fetchLimit)
+	hosts := newHostLimiter(perHostLimit)
+
+	var wg sync.WaitGroup
+	for idx, pageUrl := range pageUrls {
+		wg.Add(1)
+		go func(idx int, pageUrl string) {
+			defer wg.Done()
+
+			// Wait for the host first so a busy host doesn't hold global slots
+			host := hostOf(pageUrl)
+			hosts.acquire(host)
+			defer hosts.release(host)
+			global <- struct{}{}
+			defer func() { <-global }()
+
+			article, err := fetch(pageUrl)
+			if err != nil {
+				util.Red.Printf("Couldn't convert %s because %s\n", pageUrl, err)
+				util.Magenta.Println("SKIPPING ", pageUrl)
+				return
+			}
+			util.Green.Printf("Fetched %s --> %s\n", pageUrl, article.Title)
+			results[idx] = &article
+		}(idx, pageUrl)
+	}
+	wg.Wait()
+
+	articles := make([]Article, 0, len(pageUrls))
+	for _, article := range results {
+		if article != nil {
+			articles = append(articles, *article)
+		}
+	}
+	return articles
+}
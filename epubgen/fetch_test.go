@@ -0,0 +1,61 @@
+package epubgen
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchAllKeepsOrderAndHostLimit(t *testing.T) {
+	text := strings.Repeat("This paragraph has enough words, and commas, for readability to keep it. ", 6)
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		// Later pages answer first
+		var num int
+		fmt.Sscanf(r.URL.Path, "/page%d", &num)
+		time.Sleep(time.Duration(10-num) * 5 * time.Millisecond)
+		fmt.Fprintf(w, `<html><head><title>Page %d</title></head><body><article><h1>Page %d</h1><p>%s</p></article></body></html>`, num, num, text)
+	}))
+	defer server.Close()
+
+	savedTotal, savedHost := fetchLimit, perHostLimit
+	defer func() { fetchLimit, perHostLimit = savedTotal, savedHost }()
+	SetFetchLimits(6, 2)
+
+	var urls, want []string
+	for i := 1; i <= 8; i++ {
+		urls = append(urls, fmt.Sprintf("%s/page%d", server.URL, i))
+		want = append(want, fmt.Sprintf("Page %d", i))
+		if i == 4 {
+			// Nothing listens there
+			urls = append(urls, "http://127.0.0.1:1/missing")
+		}
+	}
+	var got []string
+	for _, article := range fetchAll(urls) {
+		got = append(got, article.Title)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected the articles in the order of the urls without the failed one, got %v", got)
+	}
+	if maxInFlight > perHostLimit {
+		t.Errorf("expected at most %d requests at once to the host, got %d", perHostLimit, maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("expected the pages to be fetched in parallel, got %d at most", maxInFlight)
+	}
+}
@@ -0,0 +1,71 @@
+package epubgen
+
+import (
+	"fmt"
+)
+
+// Output formats of books
+const (
+	FormatEPUB     = "epub"
+	FormatKEPUB    = "kepub" // EPUB with the markup Kobo readers use for pagination and reading stats
+	FormatFB2      = "fb2"   // FictionBook 2, for PocketBook and Android readers
+	FormatHTML     = "html"  // A single HTML file with images inlined
+	FormatMarkdown = "md"    // Markdown notes with front matter, images in an attachments folder
+	FormatPDF      = "pdf"   // Paginated for a page size, for reMarkable and large screen readers
+)
+
+// FormatNames returns the output formats books can be written in
+func FormatNames() []string {
+	return []string{FormatEPUB, FormatKEPUB, FormatFB2, FormatHTML, FormatMarkdown, FormatPDF}
+}
+
+// fileExtension returns the extension of a book in the given format
+func fileExtension(format string) string {
+	switch format {
+	case FormatKEPUB:
+		return ".kepub.epub"
+	case FormatFB2:
+		return ".fb2"
+	case FormatHTML:
+		return ".html"
+	case FormatMarkdown:
+		return ".md"
+	case FormatPDF:
+		return ".pdf"
+	}
+	return ".epub"
+}
+
+// bookWriter renders fetched articles to one output format
+type bookWriter interface {
+	// addImage stores an image in the book and returns the src articles
+	// refer to it by. Calls may come from several goroutines, one at a time.
+	addImage(name string, img processedImage) (string, error)
+	// setCover stores the cover image of the book
+	setCover(name string, img processedImage) error
+	// addContent adds the articles, their images are already stored
+	addContent(articles *[]Article) error
+	// write saves the book to a file
+	write(filepath string) error
+}
+
+// newBookWriter returns the writer for the output format of the options
+func newBookWriter(title string, opts Options) (bookWriter, error) {
+	switch opts.Format {
+	case "", FormatEPUB, FormatKEPUB:
+		book := NewEpubmaker(title, opts)
+		if err := book.addStylesheet(); err != nil {
+			return nil, err
+		}
+		return book, nil
+	case FormatFB2:
+		return newFB2Writer(title, opts), nil
+	case FormatHTML:
+		return newHTMLWriter(title, opts)
+	case FormatMarkdown:
+		return newMarkdownWriter(title, opts), nil
+	case FormatPDF:
+		return newPDFWriter(title, opts)
+	}
+	return nil, fmt.Errorf("unknown output format %q, available formats are %v", opts.Format, FormatNames())
+}
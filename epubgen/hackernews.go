@@ -0,0 +1,103 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterAdapter("news.ycombinator.com", AdapterFunc(fetchHackerNews))
+}
+
+// The Algolia API returns a whole item with its comment tree in one request,
+// the official API needs one per comment
+var hackerNewsAPI = "https://hn.algolia.com/api/v1/items/"
+
+var hackerNewsID = regexp.MustCompile(`^\d+$`)
+
+// hnItem is a story or comment of the Algolia API
+type hnItem struct {
+	Author    string   `json:"author"`
+	Title     string   `json:"title"`
+	URL       string   `json:"url"`
+	Text      string   `json:"text"`
+	Points    *int     `json:"points"`
+	CreatedAt int64    `json:"created_at_i"`
+	Children  []hnItem `json:"children"`
+}
+
+// fetchHackerNews turns an item page into its thread, other pages of the
+// site are left to readability
+func fetchHackerNews(pageURL *url.URL) (Article, error) {
+	id := pageURL.Query().Get("id")
+	if pageURL.Path != "/item" || !hackerNewsID.MatchString(id) {
+		return Article{}, ErrSkipAdapter
+	}
+	data, status, err := fetchPage(hackerNewsAPI + id)
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("hacker news api: " + http.StatusText(status))
+	}
+	var item hnItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Article{}, err
+	}
+	return discussionArticle(hackerNewsDiscussion(item), pageURL.String(), discussionOptions), nil
+}
+
+// hackerNewsDiscussion converts an item of the API into a thread
+func hackerNewsDiscussion(item hnItem) discussion {
+	d := discussion{
+		Title:    item.Title,
+		Author:   item.Author,
+		Link:     item.URL,
+		Body:     hackerNewsText(item.Text),
+		Created:  time.Unix(item.CreatedAt, 0).UTC(),
+		Comments: hackerNewsComments(item.Children),
+	}
+	if len(d.Title) == 0 {
+		// A comment linked directly
+		d.Title = "Comment by " + item.Author
+	}
+	if item.Points != nil {
+		d.Score, d.HasScore = *item.Points, true
+	}
+	return d
+}
+
+func hackerNewsComments(items []hnItem) []comment {
+	var comments []comment
+	for _, item := range items {
+		c := comment{
+			Author:  item.Author,
+			Body:    hackerNewsText(item.Text),
+			Created: time.Unix(item.CreatedAt, 0).UTC(),
+			Replies: hackerNewsComments(item.Children),
+		}
+		if len(c.Author) == 0 && len(item.Text) == 0 {
+			// Deleted, kept for its replies
+			if len(c.Replies) == 0 {
+				continue
+			}
+			c.Author, c.Body = "[deleted]", ""
+		}
+		comments = append(comments, c)
+	}
+	return comments
+}
+
+// hackerNewsText wraps the text of an item in a paragraph, older items
+// separate paragraphs with <p> without opening the first
+func hackerNewsText(text string) string {
+	if len(text) == 0 || strings.HasPrefix(text, "<p>") {
+		return text
+	}
+	return "<p>" + text + "</p>"
+}
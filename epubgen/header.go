@@ -0,0 +1,190 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	htmlutil "html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Average reading speed used for the reading time estimate
+const wordsPerMinute = 230
+
+// Meta tags publishers use for the publication date, most reliable first
+var publishedSelectors = []string{
+	`meta[property="article:published_time"]`,
+	`meta[name="article:published_time"]`,
+	`meta[property="og:published_time"]`,
+	`meta[itemprop="datePublished"]`,
+	`meta[name="parsely-pub-date"]`,
+	`meta[name="publish-date"]`,
+	`meta[name="pubdate"]`,
+	`meta[name="DC.date.issued"]`,
+	`meta[name="dc.date"]`,
+	`meta[name="date"]`,
+}
+
+var jsonLDPublished = regexp.MustCompile(`"datePublished"\s*:\s*"([^"]+)"`)
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05.000Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123,
+	time.RFC1123Z,
+	"January 2, 2006",
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// publishedDate looks for the publication date in the metadata of a page,
+// returns the zero time if there is none
+func publishedDate(page []byte) time.Time {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return time.Time{}
+	}
+	for _, selector := range publishedSelectors {
+		if content, ok := doc.Find(selector).First().Attr("content"); ok {
+			if t := parseDate(content); !t.IsZero() {
+				return t
+			}
+		}
+	}
+	if match := jsonLDPublished.FindSubmatch(page); match != nil {
+		if t := parseDate(string(match[1])); !t.IsZero() {
+			return t
+		}
+	}
+	for _, selector := range []string{`time[itemprop="datePublished"]`, `time[pubdate]`, `article time[datetime]`} {
+		if datetime, ok := doc.Find(selector).First().Attr("datetime"); ok {
+			if t := parseDate(datetime); !t.IsZero() {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// articleText returns the plain text of an article
+func articleText(article *Article) string {
+	if len(article.TextContent) > 0 || article.Node == nil {
+		return article.TextContent
+	}
+	return goquery.NewDocumentFromNode(article.Node).Text()
+}
+
+// readingMinutes estimates how long the article takes to read, at least a minute
+func readingMinutes(article *Article) int {
+	words := len(strings.Fields(articleText(article)))
+	return max((words+wordsPerMinute/2)/wordsPerMinute, 1)
+}
+
+// siteName returns the name of the website, or its domain if the page doesn't name itself
+func siteName(article *Article) string {
+	if name := strings.TrimSpace(article.SiteName); len(name) > 0 {
+		return name
+	}
+	if u, err := url.Parse(article.Source); err == nil {
+		return strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	return ""
+}
+
+// byline returns the author line of an article, prefixed with "By"
+func byline(article *Article) string {
+	author := strings.TrimSpace(article.Byline)
+	if len(author) == 0 || strings.HasPrefix(strings.ToLower(author), "by ") {
+		return author
+	}
+	return "By " + author
+}
+
+// articleDetails returns the site, publication date and reading time of an article
+func articleDetails(article *Article) string {
+	var details []string
+	if site := siteName(article); len(site) > 0 {
+		details = append(details, site)
+	}
+	if !article.Published.IsZero() {
+		details = append(details, article.Published.Format("2 January 2006"))
+	}
+	details = append(details, fmt.Sprintf("%d min read", readingMinutes(article)))
+	return strings.Join(details, " · ")
+}
+
+// articleHeader renders the block shown at the start of every article: title,
+// byline, site, publication date, reading time and the original url
+func articleHeader(article *Article) string {
+	var header strings.Builder
+	header.WriteString(`<div class="article-header">`)
+	header.WriteString("<h1>" + htmlutil.EscapeString(article.Title) + "</h1>")
+
+	if author := byline(article); len(author) > 0 {
+		header.WriteString(`<p class="byline">` + htmlutil.EscapeString(author) + "</p>")
+	}
+
+	header.WriteString(`<p class="article-meta">` + htmlutil.EscapeString(articleDetails(article)) + "</p>")
+
+	if len(article.Source) > 0 {
+		source := htmlutil.EscapeString(article.Source)
+		header.WriteString(`<p class="article-source"><a href="` + source + `">` + source + "</a></p>")
+	}
+	header.WriteString("</div>")
+	return header.String()
+}
+
+// bookLanguage returns the language the articles are written in when those
+// telling agree on one, empty otherwise
+func bookLanguage(articles []Article) string {
+	var lang string
+	for _, article := range articles {
+		if len(article.Language) == 0 {
+			continue
+		}
+		if len(lang) > 0 && article.Language != lang {
+			return ""
+		}
+		lang = article.Language
+	}
+	return lang
+}
+
+// articleDescription summarises an article for the book metadata
+func articleDescription(article *Article) string {
+	var parts []string
+	if excerpt := strings.TrimSpace(article.Excerpt); len(excerpt) > 0 {
+		parts = append(parts, excerpt)
+	}
+	var published []string
+	if site := siteName(article); len(site) > 0 {
+		published = append(published, site)
+	}
+	if !article.Published.IsZero() {
+		published = append(published, article.Published.Format("2 January 2006"))
+	}
+	if len(published) > 0 {
+		parts = append(parts, strings.Join(published, ", "))
+	}
+	if len(article.Source) > 0 {
+		parts = append(parts, article.Source)
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,121 @@
+package epubgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArticleHeader(t *testing.T) {
+	words := func(n int) string { return strings.TrimSpace(strings.Repeat("word ", n)) }
+	published := time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		article Article
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "everything",
+			article: withPublished(NewArticle("Fish & Chips", "Jane Doe", "<p>"+words(460)+"</p>", "https://www.example.com/fish?a=1&b=2"), published),
+			want: []string{
+				`<div class="article-header"><h1>Fish &amp; Chips</h1>`,
+				`<p class="byline">By Jane Doe</p>`,
+				`<p class="article-meta">example.com · 5 March 2024 · 2 min read</p>`,
+				`<p class="article-source"><a href="https://www.example.com/fish?a=1&amp;b=2">https://www.example.com/fish?a=1&amp;b=2</a></p></div>`,
+			},
+		},
+		{
+			name:    "byline already prefixed",
+			article: NewArticle("Title", "by Someone", "<p>Short</p>", "https://example.com/a"),
+			want:    []string{`<p class="byline">by Someone</p>`, `example.com · 1 min read`},
+		},
+		{
+			name:    "rounds down below half a minute",
+			article: NewArticle("Title", "", "<p>"+words(344)+"</p>", ""),
+			want:    []string{`<p class="article-meta">1 min read</p>`},
+			notWant: []string{`class="byline"`, `class="article-source"`},
+		},
+		{
+			name:    "rounds up from half a minute",
+			article: NewArticle("Title", "", "<p>"+words(345)+"</p>", ""),
+			want:    []string{`<p class="article-meta">2 min read</p>`},
+		},
+		{
+			name:    "site name over domain",
+			article: withSiteName(NewArticle("Title", "", "", "https://example.com/a"), "The Example"),
+			want:    []string{`<p class="article-meta">The Example · 1 min read</p>`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := articleHeader(&test.article)
+			for _, want := range test.want {
+				if !strings.Contains(header, want) {
+					t.Errorf("expected %s in %s", want, header)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(header, notWant) {
+					t.Errorf("didn't expect %s in %s", notWant, header)
+				}
+			}
+		})
+	}
+}
+
+func withPublished(article Article, published time.Time) Article {
+	article.Published = published
+	return article
+}
+
+func withSiteName(article Article, name string) Article {
+	article.SiteName = name
+	return article
+}
+
+func TestPublishedDate(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"open graph", `<meta property="article:published_time" content="2024-03-05T10:00:00+02:00">`, "2024-03-05"},
+		{"first reliable tag wins", `<meta name="date" content="2020-01-01"><meta property="article:published_time" content="2024-03-05">`, "2024-03-05"},
+		{"unparsable tag skipped", `<meta property="article:published_time" content="yesterday"><meta name="pubdate" content="March 5, 2024">`, "2024-03-05"},
+		{"json-ld", `<script type="application/ld+json">{"datePublished": "2024-03-05T10:00:00Z"}</script>`, "2024-03-05"},
+		{"time element", `<article><time datetime="2024-03-05 10:00:00">5 March</time></article>`, "2024-03-05"},
+		{"none", `<p>No date here</p>`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := publishedDate([]byte("<html><head></head><body>" + test.page + "</body></html>"))
+			if len(test.want) == 0 {
+				if !got.IsZero() {
+					t.Errorf("expected no date, got %s", got)
+				}
+				return
+			}
+			if got.Format("2006-01-02") != test.want {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSingleArticleMetadata(t *testing.T) {
+	article := NewArticle("Fish", "Jane Doe", "<p>Some text</p>", "https://www.example.com/fish")
+	article.Excerpt = "All about fish"
+	article.Published = time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	path, err := makeBook([]Article{article}, "Fish", t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opf := bookFile(t, path, ".opf")
+	for _, want := range []string{">Jane Doe</dc:creator>", "All about fish", "example.com, 5 March 2024", "https://www.example.com/fish"} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("expected %s in the metadata, got %s", want, opf)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package epubgen
+
+import (
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"os"
+	"strings"
+
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// htmlWriter writes a book as a single HTML file, images and stylesheet
+// are inlined so the file works on its own
+type htmlWriter struct {
+	title string
+	opts  Options
+	css   []byte
+	cover string // Data url of the cover image
+	toc   strings.Builder
+	body  strings.Builder
+	lang  string
+	// Metadata of single article books
+	author      string
+	description string
+}
+
+func newHTMLWriter(title string, opts Options) (*htmlWriter, error) {
+	css, err := stylesheet(opts.Theme, opts.Stylesheet)
+	if err != nil {
+		return nil, err
+	}
+	return &htmlWriter{title: title, opts: opts, css: css}, nil
+}
+
+func (w *htmlWriter) addImage(name string, img processedImage) (string, error) {
+	return dataURL(img.mediaType, img.data), nil
+}
+
+func (w *htmlWriter) setCover(name string, img processedImage) error {
+	w.cover = dataURL(img.mediaType, img.data)
+	return nil
+}
+
+func (w *htmlWriter) addContent(articles *[]Article) error {
+	if err := checkEndnotes(w.opts.Endnotes); err != nil {
+		return err
+	}
+	if len(*articles) == 0 {
+		return errors.New("No article was added, html creation failed")
+	}
+	// Everything is in one file, so notes link within the page
+	var bookNotes *endnotes
+	if w.opts.Endnotes == EndnotesBook {
+		bookNotes = newEndnotes("", "")
+	}
+
+	for idx, article := range *articles {
+		id := fmt.Sprintf("article-%d", idx+1)
+		notes := bookNotes
+		if w.opts.Endnotes == EndnotesArticle {
+			notes = newEndnotes(fmt.Sprintf("a%d-", idx+1), "")
+		}
+		content := article.Content
+		if notes != nil {
+			content = notes.rewrite(content, "", article.Source)
+		}
+
+		w.toc.WriteString(`<li><a href="#` + id + `">` + htmlutil.EscapeString(article.Title) + "</a></li>")
+		w.body.WriteString(`<section class="book-article" id="` + id + `">` + articleHeader(&article) + content)
+		if w.opts.Endnotes == EndnotesArticle && !notes.empty() {
+			w.body.WriteString(notes.render())
+		}
+		w.body.WriteString("</section>")
+	}
+	if bookNotes != nil && !bookNotes.empty() {
+		w.body.WriteString(bookNotes.render())
+	}
+	util.Green.Printf("Added %d articles\n", len(*articles))
+
+	w.lang = bookLanguage(*articles)
+	if len(*articles) == 1 {
+		article := (*articles)[0]
+		w.author = strings.TrimSpace(article.Byline)
+		w.description = articleDescription(&article)
+	}
+	return nil
+}
+
+func (w *htmlWriter) write(filepath string) error {
+	var out strings.Builder
+	out.WriteString("<!DOCTYPE html>\n")
+	if len(w.lang) > 0 {
+		out.WriteString(`<html lang="` + htmlutil.EscapeString(w.lang) + `">` + "\n<head>\n")
+	} else {
+		out.WriteString("<html>\n<head>\n")
+	}
+	out.WriteString(`<meta charset="utf-8"/>` + "\n")
+	out.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1"/>` + "\n")
+	out.WriteString("<title>" + htmlutil.EscapeString(w.title) + "</title>\n")
+	if len(w.author) > 0 {
+		out.WriteString(`<meta name="author" content="` + htmlutil.EscapeString(w.author) + `"/>` + "\n")
+	}
+	if len(w.description) > 0 {
+		out.WriteString(`<meta name="description" content="` + htmlutil.EscapeString(w.description) + `"/>` + "\n")
+	}
+	out.WriteString("<style>\n")
+	out.Write(w.css)
+	out.WriteString("</style>\n</head>\n<body>\n")
+
+	if len(w.cover) > 0 {
+		out.WriteString(`<div class="book-cover"><img src="` + w.cover + `" alt="` + htmlutil.EscapeString(w.title) + `"/></div>` + "\n")
+	}
+	out.WriteString(`<nav class="book-toc"><h1>` + htmlutil.EscapeString(w.title) + "</h1><ol>" + w.toc.String() + "</ol></nav>\n")
+	out.WriteString(w.body.String())
+	out.WriteString("\n</body>\n</html>\n")
+
+	return os.WriteFile(filepath, []byte(out.String()), 0644)
+}
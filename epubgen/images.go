@@ -0,0 +1,409 @@
+package epubgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Register WebP decoder
+)
+
+// Number of images downloaded and compressed at the same time, across all articles
+const imageWorkers = 6
+
+// downloadImage fetches the raw bytes of an image
+func downloadImage(imgURL string) ([]byte, error) {
+	// Images inlined in the page, like those of the docs of a repository
+	if strings.HasPrefix(imgURL, "data:") {
+		return readDataURL(imgURL)
+	}
+	client := getHTTPClient()
+
+	req, err := http.NewRequest("GET", imgURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to download image: " + resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// processedImage is an image re-encoded for the ereader, ready to be embedded
+type processedImage struct {
+	data      []byte
+	mediaType string
+	ext       string
+}
+
+// sniffImage returns the media type of image data based on its content
+func sniffImage(imgData []byte) string {
+	head := bytes.TrimSpace(imgData[:min(len(imgData), 512)])
+	if bytes.HasPrefix(head, []byte("<svg")) || (bytes.HasPrefix(head, []byte("<?xml")) && bytes.Contains(head, []byte("<svg"))) {
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(imgData)
+}
+
+// isOpaque reports whether every pixel of img is fully opaque
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// flatten draws img over a white background, transparent areas would
+// otherwise show up black or in random colours on e-ink screens
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+	return flat
+}
+
+// Line art has few distinct colours, photos have thousands
+const lineArtMaxColors = 256
+
+// isLineArt guesses if img is a diagram, chart or screenshot by counting
+// colours on a grid of sample points. These stay sharp as PNG and blur as JPEG.
+func isLineArt(img image.Image) bool {
+	bounds := img.Bounds()
+	step := 1
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > 250000 {
+		step++
+	}
+	colors := make(map[[4]uint32]bool)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, a := img.At(x, y).RGBA()
+			colors[[4]uint32{r, g, b, a}] = true
+			if len(colors) > lineArtMaxColors {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// toGray converts img to grayscale, smaller and closer to what e-ink shows
+func toGray(img image.Image) image.Image {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	return gray
+}
+
+// resize scales img down proportionally to fit in maxWidth x maxHeight
+func resize(img image.Image, maxWidth int, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	origWidth := bounds.Dx()
+	origHeight := bounds.Dy()
+
+	if origWidth <= maxWidth && origHeight <= maxHeight {
+		return img
+	}
+
+	widthRatio := float64(maxWidth) / float64(origWidth)
+	heightRatio := float64(maxHeight) / float64(origHeight)
+	ratio := widthRatio
+	if heightRatio < widthRatio {
+		ratio = heightRatio
+	}
+	newWidth := max(int(float64(origWidth)*ratio), 1)
+	newHeight := max(int(float64(origHeight)*ratio), 1)
+
+	var resized draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		resized = image.NewGray(image.Rect(0, 0, newWidth, newHeight))
+	} else {
+		resized = image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	}
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
+	util.Cyan.Printf("Resized image from %dx%d to %dx%d\n", origWidth, origHeight, newWidth, newHeight)
+	return resized
+}
+
+// encodeImage encodes img as PNG or as JPEG with the given quality
+func encodeImage(img image.Image, asPNG bool, quality int) (processedImage, error) {
+	var buf bytes.Buffer
+	if asPNG {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := encoder.Encode(&buf, img); err != nil {
+			return processedImage{}, err
+		}
+		return processedImage{data: buf.Bytes(), mediaType: "image/png", ext: ".png"}, nil
+	}
+
+	// Encode as JPEG with compression
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return processedImage{}, err
+	}
+	return processedImage{data: buf.Bytes(), mediaType: "image/jpeg", ext: ".jpg"}, nil
+}
+
+// Lowest JPEG quality and number of downscales tried to fit an image in the size limit
+const (
+	minJPEGQuality = 40
+	maxShrinkSteps = 6
+)
+
+// processImage converts downloaded image data into something the device of
+// the profile renders well. With the auto image format photos become JPEG,
+// line art and transparent images stay PNG, GIFs keep their first frame and
+// WebP is decoded. Transparent images are flattened onto white. Profiles with
+// gray levels also go through the e-ink stage. SVG is passed through untouched.
+func processImage(imgData []byte, profile config.Profile) (processedImage, error) {
+	mediaType := sniffImage(imgData)
+	if mediaType == "image/svg+xml" {
+		return processedImage{data: imgData, mediaType: mediaType, ext: ".svg"}, nil
+	}
+
+	// gif.Decode only returns the first frame, which is what we want for animations
+	img, format, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return processedImage{}, fmt.Errorf("unsupported image type %s : %w", mediaType, err)
+	}
+
+	opaque := isOpaque(img)
+	var asPNG bool
+	switch profile.ImageFormat {
+	case config.ImageFormatPNG:
+		asPNG = true
+	case config.ImageFormatJPEG:
+		asPNG = false
+	default:
+		// Quantized e-ink output has exact gray levels that JPEG would blur again
+		asPNG = profile.GrayLevels > 0 || (format != "jpeg" && (!opaque || isLineArt(img)))
+	}
+
+	if !opaque {
+		img = flatten(img)
+	}
+	if profile.Grayscale {
+		img = toGray(img)
+	}
+
+	// Resizing mixes colours, so the e-ink stage runs on the resized image
+	render := func(width int, height int) image.Image {
+		out := resize(img, width, height)
+		if profile.GrayLevels > 0 {
+			out = einkProcess(out, profile)
+		}
+		return out
+	}
+
+	width, height := profile.Width, profile.Height
+	quality := profile.Quality
+	processed, err := encodeImage(render(width, height), asPNG, quality)
+	if err != nil {
+		return processedImage{}, err
+	}
+
+	// Lower the quality, then the resolution, until the image fits the profile
+	limit := profile.MaxImageKB * 1024
+	for steps := 0; limit > 0 && len(processed.data) > limit && steps < maxShrinkSteps; {
+		if !asPNG && quality > minJPEGQuality {
+			quality = max(quality-10, minJPEGQuality)
+		} else {
+			width, height = width*4/5, height*4/5
+			steps++
+		}
+		processed, err = encodeImage(render(width, height), asPNG, quality)
+		if err != nil {
+			return processedImage{}, err
+		}
+	}
+	return processed, nil
+}
+
+// dataURL embeds data in a data url
+func dataURL(mediaType string, data []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// readDataURL returns the data embedded in a data url
+func readDataURL(dataURL string) ([]byte, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok {
+		return nil, errors.New("invalid data url")
+	}
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	text, err := url.PathUnescape(data)
+	return []byte(text), err
+}
+
+// imageStore downloads the images of a book and hands them to the writer of
+// the output format
+type imageStore struct {
+	book    bookWriter
+	profile config.Profile
+	// guards downloads, hashes and image additions to book
+	mu sync.Mutex
+	// image url -> reference of the stored image
+	downloads map[string]string
+	// hash of downloaded image content -> reference of the stored image
+	hashes map[string]string
+}
+
+func newImageStore(book bookWriter, profile config.Profile) *imageStore {
+	return &imageStore{
+		book:      book,
+		profile:   profile,
+		downloads: make(map[string]string),
+		hashes:    make(map[string]string),
+	}
+}
+
+// Download an image, convert it for the ereader and add it to the book. Images with the
+// same content are only added once, even if they come from different urls.
+// Safe to call from multiple goroutines.
+func (e *imageStore) addImage(imgSrc string) {
+	imgData, err := downloadImage(imgSrc)
+	if err != nil {
+		util.Red.Printf("Couldn't download image %s : %s\n", imgSrc, err)
+		return
+	}
+	sum := util.HashBytes(imgData)
+
+	e.mu.Lock()
+	if imgRef, ok := e.hashes[sum]; ok {
+		e.downloads[imgSrc] = imgRef
+		e.mu.Unlock()
+		util.Magenta.Printf("Image %s already embedded as %s\n", imgSrc, imgRef)
+		return
+	}
+	e.mu.Unlock()
+
+	processed, err := processImage(imgData, e.profile)
+	if err != nil {
+		util.Red.Printf("Couldn't process image %s : %s\n", imgSrc, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Another worker may have embedded the same content in the meantime
+	if imgRef, ok := e.hashes[sum]; ok {
+		e.downloads[imgSrc] = imgRef
+		return
+	}
+
+	//pass unique and safe image names here, then it will not crash on windows
+	//use murmur hash of the content to generate file name
+	imageFileName := "img" + sum + processed.ext
+
+	imgRef, err := e.book.addImage(imageFileName, processed)
+	if err != nil {
+		util.Red.Printf("Couldn't add image %s : %s\n", imgSrc, err)
+		return
+	}
+
+	util.Green.Printf("Added image %s as %s (%dKB)\n", imgSrc, processed.mediaType, len(processed.data)/1024)
+	e.hashes[sum] = imgRef
+	e.downloads[imgSrc] = imgRef
+}
+
+// Point remote image link to downloaded image
+func (e *imageStore) changeRefs(i int, img *goquery.Selection) {
+	img.RemoveAttr("loading")
+	img.RemoveAttr("srcset")
+	imgSrc, exists := img.Attr("src")
+	if exists {
+		if imgRef, ok := e.downloads[imgSrc]; ok {
+			util.Green.Printf("Setting img src from %s to %s \n", imgSrc, imgRef)
+			img.SetAttr("src", imgRef)
+		}
+	}
+}
+
+// Fetches images of all articles with a bounded pool of workers and then
+// embeds them into the book. Articles without a parsed node are left untouched.
+func (e *imageStore) embedImages(articles []Article) {
+	util.CyanBold.Println("Downloading Images")
+
+	docs := make([]*goquery.Document, len(articles))
+	seen := make(map[string]bool)
+	var sources []string
+	for i := range articles {
+		if articles[i].Node == nil {
+			continue
+		}
+		docs[i] = goquery.NewDocumentFromNode(articles[i].Node)
+		docs[i].Find("img").Each(func(_ int, img *goquery.Selection) {
+			imgSrc, exists := img.Attr("src")
+			//don't download same thing twice
+			if exists && !seen[imgSrc] {
+				seen[imgSrc] = true
+				sources = append(sources, imgSrc)
+			}
+		})
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < imageWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for imgSrc := range jobs {
+				e.addImage(imgSrc)
+			}
+		}()
+	}
+	for _, imgSrc := range sources {
+		jobs <- imgSrc
+	}
+	close(jobs)
+	wg.Wait()
+
+	//Change all refs, doing it after all downloads so repeated images are fetched only once
+	for i, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		util.Cyan.Println("Embedding images in ", articles[i].Title)
+		doc.Find("img").Each(e.changeRefs)
+
+		content, err := doc.Html()
+		if err != nil {
+			util.Red.Printf("Error converting modified %s to HTML, it will be transferred without images : %s \n", articles[i].Title, err)
+		} else {
+			articles[i].Content = content
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-shiori/go-readability"
+	"github.com/nikhil1raghav/kindle-send/config"
+)
+
+func testImage(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 30; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 8), 120, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEmbedImagesDeduplicatesContent(t *testing.T) {
+	logo := testImage(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(logo)
+	}))
+	defer server.Close()
+
+	// The same logo served from different urls in different articles
+	var articles []Article
+	for i := 0; i < 4; i++ {
+		body := fmt.Sprintf(`<body><p>Article %d</p><img src="%s/cdn%d/logo.png"><img src="%s/shared.png"></body>`, i, server.URL, i, server.URL)
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		articles = append(articles, Article{Article: readability.Article{Title: fmt.Sprint(i), Node: doc.Find("body").Get(0)}})
+	}
+
+	opts, err := NewOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := newImageStore(NewEpubmaker("test", opts), opts.Profile)
+	book.embedImages(articles)
+
+	if len(book.hashes) != 1 {
+		t.Fatalf("expected 1 embedded image, got %d", len(book.hashes))
+	}
+	if len(book.downloads) != 5 {
+		t.Fatalf("expected 5 resolved urls, got %d", len(book.downloads))
+	}
+	for _, article := range articles {
+		if strings.Contains(article.Content, server.URL) {
+			t.Errorf("article %s still points to remote images: %s", article.Title, article.Content)
+		}
+	}
+}
+
+func TestDownloadImageReadsNoLocalFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.png")
+	if err := os.WriteFile(path, testImage(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := downloadImage("file://" + filepath.ToSlash(path)); err == nil {
+		t.Error("expected file urls to be refused")
+	}
+	data, err := downloadImage(dataURL("image/png", testImage(t)))
+	if err != nil || !bytes.Equal(data, testImage(t)) {
+		t.Errorf("expected the data of the data url, got %d bytes : %v", len(data), err)
+	}
+}
+
+func TestProcessImageKeepsTypeOfContent(t *testing.T) {
+	profile, err := config.GetProfile("kindle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A gradient is photographic and should become JPEG
+	photo, err := processImage(testImage(t), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.mediaType != "image/jpeg" || photo.ext != ".jpg" {
+		t.Errorf("photo encoded as %s (%s)", photo.mediaType, photo.ext)
+	}
+
+	// A transparent two colour icon stays PNG, flattened onto white
+	icon := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for x := 5; x < 15; x++ {
+		icon.Set(x, 10, color.Black)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	processed, err := processImage(buf.Bytes(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed.mediaType != "image/png" || processed.ext != ".png" {
+		t.Fatalf("icon encoded as %s (%s)", processed.mediaType, processed.ext)
+	}
+	flat, err := png.Decode(bytes.NewReader(processed.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, a := flat.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff || a != 0xffff {
+		t.Errorf("transparent pixel not flattened onto white: %v", flat.At(0, 0))
+	}
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`)
+	if processed, _ := processImage(svg, profile); processed.ext != ".svg" {
+		t.Errorf("svg not passed through, got %s", processed.ext)
+	}
+}
+
+func TestEinkProcessReducesGrayLevels(t *testing.T) {
+	profile := config.Profile{GrayLevels: 4, Dither: true, AutoLevels: true, Sharpen: true}
+	out := einkProcess(image.NewRGBA(image.Rect(0, 0, 1, 1)), profile)
+	if len(out.Palette) != 4 {
+		t.Fatalf("expected 4 gray levels, got %d", len(out.Palette))
+	}
+
+	// A smooth gradient dithered to black and white keeps its average brightness
+	gradient := image.NewGray(image.Rect(0, 0, 64, 16))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 16; y++ {
+			gradient.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+		}
+	}
+	bw := quantize(gradient, 2, true)
+	for _, col := range []int{8, 32, 56} {
+		white := 0
+		for y := 0; y < 16; y++ {
+			if bw.ColorIndexAt(col, y) == 1 {
+				white++
+			}
+		}
+		want := col * 4 * 16 / 255
+		if white < want-4 || white > want+4 {
+			t.Errorf("column %d has %d white pixels, expected about %d", col, white, want)
+		}
+	}
+}
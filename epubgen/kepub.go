@@ -0,0 +1,139 @@
+package epubgen
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose text starts a new kobo paragraph
+var koboBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Li: true, atom.Blockquote: true, atom.Pre: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Td: true, atom.Th: true, atom.Dt: true, atom.Dd: true, atom.Figcaption: true,
+	atom.Table: true, atom.Ul: true, atom.Ol: true, atom.Figure: true, atom.Section: true,
+	atom.Article: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+}
+
+// Elements whose content is never wrapped
+var koboSkip = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Svg: true, atom.Math: true,
+}
+
+// splitSentences splits text after every sentence ending punctuation that is
+// followed by white space, the white space stays with the sentence before it
+func splitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(".!?…", runes[i]) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(`"'”’)]»`, runes[end]) {
+			end++
+		}
+		if end >= len(runes) || !unicode.IsSpace(runes[end]) {
+			continue
+		}
+		for end < len(runes) && unicode.IsSpace(runes[end]) {
+			end++
+		}
+		sentences = append(sentences, string(runes[start:end]))
+		start, i = end, end-1
+	}
+	if start < len(runes) {
+		sentences = append(sentences, string(runes[start:]))
+	}
+	return sentences
+}
+
+// koboSpanner numbers kobo spans as kobo.<paragraph>.<segment>
+type koboSpanner struct {
+	para  int
+	seg   int
+	fresh bool // Next text starts a new paragraph
+}
+
+func (k *koboSpanner) span(children ...*html.Node) *html.Node {
+	if k.fresh {
+		k.para, k.seg, k.fresh = k.para+1, 0, false
+	}
+	k.seg++
+	span := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "span",
+		DataAtom: atom.Span,
+		Attr: []html.Attribute{
+			{Key: "class", Val: "koboSpan"},
+			{Key: "id", Val: fmt.Sprintf("kobo.%d.%d", k.para, k.seg)},
+		},
+	}
+	for _, child := range children {
+		span.AppendChild(child)
+	}
+	return span
+}
+
+func (k *koboSpanner) wrap(n *html.Node, inPre bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.TextNode:
+			if len(strings.TrimSpace(c.Data)) == 0 {
+				break
+			}
+			sentences := []string{c.Data}
+			if !inPre {
+				sentences = splitSentences(c.Data)
+			}
+			for _, sentence := range sentences {
+				n.InsertBefore(k.span(&html.Node{Type: html.TextNode, Data: sentence}), c)
+			}
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && c.DataAtom == atom.Img:
+			// Images get a paragraph of their own
+			k.fresh = true
+			n.RemoveChild(c)
+			n.InsertBefore(k.span(c), next)
+			k.fresh = true
+		case c.Type == html.ElementNode && !koboSkip[c.DataAtom]:
+			block := koboBlocks[c.DataAtom]
+			if block {
+				k.fresh = true
+			}
+			k.wrap(c, inPre || c.DataAtom == atom.Pre)
+			if block {
+				k.fresh = true
+			}
+		}
+		c = next
+	}
+}
+
+// kepubify adds the markup of Kobo books to the body of a section: text
+// wrapped in numbered koboSpans by sentence, inside the book-columns and
+// book-inner divs
+func kepubify(body string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return body
+	}
+	root := doc.Find("body").Get(0)
+	if root == nil {
+		return body
+	}
+	spanner := &koboSpanner{fresh: true}
+	spanner.wrap(root, false)
+
+	inner, err := doc.Find("body").Html()
+	if err != nil {
+		return body
+	}
+	return `<div id="book-columns"><div id="book-inner">` + inner + `</div></div>`
+}
@@ -0,0 +1,97 @@
+package epubgen
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKepubify(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "sentences",
+			content: `<p>First one. Second one? Third</p>`,
+			want: []string{
+				`<div id="book-columns"><div id="book-inner"><p>`,
+				`<span class="koboSpan" id="kobo.1.1">First one. </span>`,
+				`<span class="koboSpan" id="kobo.1.2">Second one? </span>`,
+				`<span class="koboSpan" id="kobo.1.3">Third</span></p></div></div>`,
+			},
+		},
+		{
+			name:    "paragraphs",
+			content: `<h2>Title</h2><p>Some <b>bold</b> text.</p><ul><li>one</li><li>two</li></ul>`,
+			want: []string{
+				`<h2><span class="koboSpan" id="kobo.1.1">Title</span></h2>`,
+				`<p><span class="koboSpan" id="kobo.2.1">Some </span><b><span class="koboSpan" id="kobo.2.2">bold</span></b><span class="koboSpan" id="kobo.2.3"> text.</span></p>`,
+				`<li><span class="koboSpan" id="kobo.3.1">one</span></li><li><span class="koboSpan" id="kobo.4.1">two</span></li>`,
+			},
+		},
+		{
+			name:    "images",
+			content: `<p>Before <img src="a.png"/> after</p>`,
+			want: []string{
+				`<span class="koboSpan" id="kobo.1.1">Before </span>`,
+				`<span class="koboSpan" id="kobo.2.1"><img src="a.png"/></span>`,
+				`<span class="koboSpan" id="kobo.3.1"> after</span>`,
+			},
+		},
+		{
+			name:    "preformatted",
+			content: `<pre>a. b. c</pre><script>var a = 1. </script>`,
+			want: []string{
+				`<pre><span class="koboSpan" id="kobo.1.1">a. b. c</span></pre>`,
+				`<script>var a = 1. </script>`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := kepubify(test.content)
+			for _, want := range test.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected %s in %s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeKepub(t *testing.T) {
+	article := NewArticle("Kobo", "", `<p>`+strings.Repeat("Some words. ", 50)+`</p>`, "https://example.com/kobo")
+	path, err := makeBook([]Article{article}, "Kobo Book", t.TempDir(), Options{Format: FormatKEPUB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, ".kepub.epub") {
+		t.Errorf("expected a .kepub.epub file, got %s", path)
+	}
+	book, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	found := false
+	for _, file := range book.File {
+		if !strings.HasSuffix(file.Name, ".xhtml") || strings.Contains(file.Name, "nav") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if strings.Contains(string(data), `<span class="koboSpan" id="kobo.1.1">`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected kobo spans in the sections of the book")
+	}
+}
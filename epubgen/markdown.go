@@ -0,0 +1,406 @@
+package epubgen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gosimple/slug"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Folder next to the notes that holds their images
+const markdownAttachments = "attachments"
+
+// markdownWriter writes every article as a Markdown note with YAML front
+// matter, images go to the attachments folder. Books of several articles
+// get an index note linking to them.
+type markdownWriter struct {
+	title    string
+	opts     Options
+	images   map[string][]byte
+	articles []Article
+	fetched  time.Time
+}
+
+func newMarkdownWriter(title string, opts Options) *markdownWriter {
+	return &markdownWriter{
+		title:   title,
+		opts:    opts,
+		images:  make(map[string][]byte),
+		fetched: time.Now(),
+	}
+}
+
+func (w *markdownWriter) addImage(name string, img processedImage) (string, error) {
+	w.images[name] = img.data
+	return markdownAttachments + "/" + name, nil
+}
+
+// setCover does nothing, notes have no cover
+func (w *markdownWriter) setCover(name string, img processedImage) error {
+	return nil
+}
+
+func (w *markdownWriter) addContent(articles *[]Article) error {
+	if len(*articles) == 0 {
+		return errors.New("No article was added, markdown creation failed")
+	}
+	w.articles = append(w.articles, *articles...)
+	util.Green.Printf("Added %d articles\n", len(*articles))
+	return nil
+}
+
+// yamlString quotes a value for the front matter
+func yamlString(value string) string {
+	return strconv.Quote(value)
+}
+
+func (w *markdownWriter) tags() string {
+	quoted := make([]string, 0, len(w.opts.Tags))
+	for _, tag := range w.opts.Tags {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			quoted = append(quoted, yamlString(tag))
+		}
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// note renders an article as Markdown with its front matter
+func (w *markdownWriter) note(article *Article) string {
+	var out strings.Builder
+	out.WriteString("---\n")
+	out.WriteString("title: " + yamlString(article.Title) + "\n")
+	if author := strings.TrimSpace(article.Byline); len(author) > 0 {
+		out.WriteString("byline: " + yamlString(author) + "\n")
+	}
+	if len(article.Source) > 0 {
+		out.WriteString("url: " + yamlString(article.Source) + "\n")
+	}
+	if site := siteName(article); len(site) > 0 {
+		out.WriteString("site: " + yamlString(site) + "\n")
+	}
+	if !article.Published.IsZero() {
+		out.WriteString("published: " + article.Published.Format("2006-01-02") + "\n")
+	}
+	out.WriteString("fetched: " + w.fetched.Format(time.RFC3339) + "\n")
+	out.WriteString("tags: " + w.tags() + "\n")
+	out.WriteString("---\n\n")
+	out.WriteString("# " + markdownText(article.Title) + "\n\n")
+	out.WriteString(htmlToMarkdown(article.Content) + "\n")
+	return out.String()
+}
+
+func (w *markdownWriter) write(path string) error {
+	dir := filepath.Dir(path)
+	if len(w.images) > 0 {
+		attachments := filepath.Join(dir, markdownAttachments)
+		if err := os.MkdirAll(attachments, 0755); err != nil {
+			return err
+		}
+		for name, data := range w.images {
+			if err := os.WriteFile(filepath.Join(attachments, name), data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(w.articles) == 1 {
+		return os.WriteFile(path, []byte(w.note(&w.articles[0])), 0644)
+	}
+
+	// One note per article, and the book itself is an index of them
+	var index strings.Builder
+	index.WriteString("---\n")
+	index.WriteString("title: " + yamlString(w.title) + "\n")
+	index.WriteString("fetched: " + w.fetched.Format(time.RFC3339) + "\n")
+	index.WriteString("tags: " + w.tags() + "\n")
+	index.WriteString("---\n\n")
+	index.WriteString("# " + markdownText(w.title) + "\n\n")
+
+	used := map[string]bool{filepath.Base(path): true}
+	for idx, article := range w.articles {
+		name := slug.Make(article.Title)
+		if len(name) == 0 {
+			name = fmt.Sprintf("article-%d", idx+1)
+		}
+		filename := name + ".md"
+		for n := 2; used[filename]; n++ {
+			filename = fmt.Sprintf("%s-%d.md", name, n)
+		}
+		used[filename] = true
+
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(w.note(&article)), 0644); err != nil {
+			return err
+		}
+		index.WriteString("- [" + markdownText(article.Title) + "](" + filename + ")\n")
+	}
+	return os.WriteFile(path, []byte(index.String()), 0644)
+}
+
+// Characters with a meaning in Markdown, # also starts tags in Obsidian
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `#`, `\#`,
+)
+
+// markdownText escapes text so it shows as written
+func markdownText(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// Elements converted as blocks of their own, everything else is inline
+var markdownBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Footer: true, atom.Aside: true, atom.Nav: true,
+	atom.Figure: true, atom.Figcaption: true, atom.Details: true, atom.Summary: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Hr: true, atom.Table: true,
+}
+
+// htmlToMarkdown converts the HTML of an article to Markdown
+func htmlToMarkdown(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	body := doc.Find("body").Get(0)
+	if body == nil {
+		return ""
+	}
+	return markdownBlocksOf(body)
+}
+
+// markdownBlocksOf converts the children of n, runs of inline content
+// become paragraphs
+func markdownBlocksOf(n *html.Node) string {
+	var blocks []string
+	var para strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(para.String()); len(text) > 0 {
+			blocks = append(blocks, text)
+		}
+		para.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && fb2Skip[c.DataAtom] {
+			continue
+		}
+		if c.Type == html.ElementNode && markdownBlocks[c.DataAtom] {
+			flush()
+			if block := strings.TrimSpace(markdownBlock(c)); len(block) > 0 {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		para.WriteString(markdownInline(c))
+	}
+	flush()
+	return strings.Join(blocks, "\n\n")
+}
+
+// prefixLines adds prefix to every line, rest to the lines after the first
+func prefixLines(text string, first string, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if len(lines[i]) == 0 {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownBlock(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		title := strings.TrimSpace(collapseSpace(markdownInlineChildren(n)))
+		if len(title) == 0 {
+			return ""
+		}
+		return strings.Repeat("#", headingLevel(n)) + " " + title
+	case atom.Ul, atom.Ol:
+		var items []string
+		num := 0
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+				continue
+			}
+			num++
+			marker := "- "
+			if n.DataAtom == atom.Ol {
+				marker = fmt.Sprintf("%d. ", num)
+			}
+			items = append(items, prefixLines(markdownBlocksOf(li), marker, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, "\n")
+	case atom.Blockquote:
+		return prefixLines(markdownBlocksOf(n), "> ", "> ")
+	case atom.Pre:
+		code := strings.TrimRight(goquery.NewDocumentFromNode(n).Text(), "\n")
+		fence := "```"
+		if strings.Contains(code, fence) {
+			fence = "~~~"
+		}
+		return fence + codeLanguage(n) + "\n" + code + "\n" + fence
+	case atom.Hr:
+		return "---"
+	case atom.Table:
+		return markdownTable(n)
+	case atom.Figcaption:
+		if caption := strings.TrimSpace(markdownBlocksOf(n)); len(caption) > 0 {
+			return "*" + caption + "*"
+		}
+		return ""
+	case atom.Dt:
+		if term := strings.TrimSpace(markdownBlocksOf(n)); len(term) > 0 {
+			return "**" + term + "**"
+		}
+		return ""
+	}
+	return markdownBlocksOf(n)
+}
+
+// codeLanguage finds the language of a code block from classes like language-go
+func codeLanguage(pre *html.Node) string {
+	var language string
+	goquery.NewDocumentFromNode(pre).Find("[class]").AddBack().EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		for _, class := range strings.Fields(s.AttrOr("class", "")) {
+			for _, prefix := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(class, prefix) {
+					language = strings.TrimPrefix(class, prefix)
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return language
+}
+
+func markdownTable(table *html.Node) string {
+	var rows [][]string
+	goquery.NewDocumentFromNode(table).Find("tr").Each(func(_ int, tr *goquery.Selection) {
+		var cells []string
+		tr.Children().Each(func(_ int, cell *goquery.Selection) {
+			text := strings.TrimSpace(collapseSpace(markdownInlineChildren(cell.Get(0))))
+			cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+		})
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+	})
+	if len(rows) == 0 {
+		return ""
+	}
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	var out strings.Builder
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		out.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			out.WriteString(strings.Repeat("| --- ", columns) + "|\n")
+		}
+	}
+	return out.String()
+}
+
+func markdownInlineChildren(n *html.Node) string {
+	var out strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		out.WriteString(markdownInline(c))
+	}
+	return out.String()
+}
+
+// wrapInline puts marks around text, keeping surrounding spaces outside so
+// the emphasis still applies
+func wrapInline(text string, open string, closing string) string {
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) == 0 {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+	return start + open + trimmed + closing + end
+}
+
+func markdownInline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownText(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	if fb2Skip[n.DataAtom] {
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "  \n"
+	case atom.Img:
+		src := strings.TrimSpace(getAttr(n, "src"))
+		if len(src) == 0 {
+			return ""
+		}
+		return "![" + markdownText(getAttr(n, "alt")) + "](" + markdownURL(src) + ")"
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		code := goquery.NewDocumentFromNode(n).Text()
+		fence := "`"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return fence + code + fence
+	}
+
+	inner := markdownInlineChildren(n)
+	switch n.DataAtom {
+	case atom.Strong, atom.B:
+		return wrapInline(inner, "**", "**")
+	case atom.Em, atom.I, atom.Cite:
+		return wrapInline(inner, "*", "*")
+	case atom.S, atom.Strike, atom.Del:
+		return wrapInline(inner, "~~", "~~")
+	case atom.Sup, atom.Sub:
+		return "<" + n.Data + ">" + inner + "</" + n.Data + ">"
+	case atom.A:
+		href := strings.TrimSpace(getAttr(n, "href"))
+		if len(href) == 0 || strings.HasPrefix(href, "#") || len(strings.TrimSpace(inner)) == 0 {
+			return inner
+		}
+		return wrapInline(inner, "[", "]("+markdownURL(href)+")")
+	}
+	return inner
+}
+
+// markdownURL escapes the characters that would end a link early
+func markdownURL(link string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(link)
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
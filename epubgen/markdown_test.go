@@ -0,0 +1,24 @@
+package epubgen
+
+import (
+	"testing"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	content := `<h2>Setup</h2><p>Run <code>go build</code> with <strong>care </strong>and read the <a href="https://go.dev/doc">docs (new)</a>.</p>
+<ul><li>one</li><li>two<ol><li>nested</li></ol></li></ul>
+<blockquote><p>first</p><p>second # not a tag</p></blockquote>
+<pre><code class="language-go">fmt.Println("hi")</code></pre>
+<table><tr><th>a</th><th>b</th></tr><tr><td>1|2</td><td>3</td></tr></table>`
+
+	want := "## Setup\n\n" +
+		"Run `go build` with **care** and read the [docs (new)](https://go.dev/doc).\n\n" +
+		"- one\n- two\n\n  1. nested\n\n" +
+		"> first\n>\n> second \\# not a tag\n\n" +
+		"```go\nfmt.Println(\"hi\")\n```\n\n" +
+		"| a | b |\n| --- | --- |\n| 1\\|2 | 3 |"
+
+	if got := htmlToMarkdown(content); got != want {
+		t.Errorf("unexpected markdown:\n%s\n\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package epubgen
+
+import (
+	"bytes"
+	htmlutil "html"
+	"regexp"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// gfm renders GitHub flavoured Markdown. Headings get ids like GitHub's so
+// links to sections keep working, and the html of documents is kept as
+// READMEs use it for badges and collapsed sections.
+var gfm = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Linkify, extension.Strikethrough, extension.TaskList,
+		// The align attribute is gone from XHTML
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignStyle)),
+	),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	goldmark.WithRendererOptions(html.WithUnsafe(), html.WithXHTML()),
+)
+
+var mdFrontMatter = regexp.MustCompile(`(?s)^---\r?\n.*?\r?\n---\r?\n`)
+
+// markdownToHTML renders a Markdown document, front matter is left out
+func markdownToHTML(src string) string {
+	src = mdFrontMatter.ReplaceAllString(src, "")
+	var out bytes.Buffer
+	if err := gfm.Convert([]byte(src), &out); err != nil {
+		return "<pre>" + htmlutil.EscapeString(src) + "</pre>"
+	}
+	return out.String()
+}
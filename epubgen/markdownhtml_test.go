@@ -0,0 +1,32 @@
+package epubgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	for src, want := range map[string]string{
+		"Setext\n---\n":                               `<h2 id="setext">Setext</h2>`,
+		"## A *b* ##":                                 `<h2 id="a-b">A <em>b</em></h2>`,
+		"snake_case_word and _em_":                    "<p>snake_case_word and <em>em</em></p>",
+		"***both*** ~~gone~~ 2 * 3":                   "<p><em><strong>both</strong></em> <del>gone</del> 2 * 3</p>",
+		"line  \nbreak":                               "<p>line<br />\nbreak</p>",
+		"`a <b>` & &amp; <kbd>k</kbd>":                "<p><code>a &lt;b&gt;</code> &amp; &amp; <kbd>k</kbd></p>",
+		"see https://e.com/a_(b).":                    `<p>see <a href="https://e.com/a_(b)">https://e.com/a_(b)</a>.</p>`,
+		"[![ci](b.svg)](https://ci)":                  `<p><a href="https://ci"><img src="b.svg" alt="ci" /></a></p>`,
+		"[ref][] and [x]\n\n[ref]: /r 'T'":            `<p><a href="/r" title="T">ref</a> and [x]</p>`,
+		"- a\n- b\n  - c\n- [x] d":                    "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\" /> d</li>\n</ul>",
+		"3. a\n\n   more\n4. b":                       "<ol start=\"3\">\n<li>\n<p>a</p>\n<p>more</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ol>",
+		"> quote\nlazy":                               "<blockquote>\n<p>quote\nlazy</p>\n</blockquote>",
+		"```go\nx := `<`\n```":                        "<pre><code class=\"language-go\">x := `&lt;`\n</code></pre>",
+		"    code\n\n    more":                        "<pre><code>code\n\nmore\n</code></pre>",
+		"| a | b |\n|--:|---|\n| `x\\|y` | 2 |":       "<tr>\n<td style=\"text-align:right\"><code>x|y</code></td>\n<td>2</td>\n</tr>",
+		"<details>\n<summary>S</summary>\n</details>": "<details>\n<summary>S</summary>\n</details>",
+		"---\ntitle: x\n---\nBody":                    "<p>Body</p>",
+	} {
+		if got := markdownToHTML(src); !strings.Contains(got, want) {
+			t.Errorf("markdownToHTML(%q) = %q, want %q in it", src, got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func init() {
+	RegisterAdapter("*", AdapterFunc(fetchMastodon))
+}
+
+// Mastodon runs on any host, so statuses are recognised by their path:
+// /@user/id, /@user@instance/id for remote users, or /users/user/statuses/id
+var mastodonPath = regexp.MustCompile(`^/(?:@[\w.-]+(?:@[\w.-]+)?|users/[\w.-]+/statuses)/(\d+)/?$`)
+
+// mastodonStatus is a status of the Mastodon API
+type mastodonStatus struct {
+	ID          string          `json:"id"`
+	InReplyToID string          `json:"in_reply_to_id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	URL         string          `json:"url"`
+	Content     string          `json:"content"`
+	SpoilerText string          `json:"spoiler_text"`
+	Account     mastodonAccount `json:"account"`
+	Media       []mastodonMedia `json:"media_attachments"`
+}
+
+type mastodonAccount struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Acct        string `json:"acct"`
+	DisplayName string `json:"display_name"`
+}
+
+// mastodonMedia is an attachment, type is image, gifv, video or audio
+type mastodonMedia struct {
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	PreviewURL  string `json:"preview_url"`
+	Description string `json:"description"`
+}
+
+// mastodonContext is what comes before and after a status in its thread,
+// descendants in the order they are read
+type mastodonContext struct {
+	Ancestors   []mastodonStatus `json:"ancestors"`
+	Descendants []mastodonStatus `json:"descendants"`
+}
+
+// mastodonStatusID returns the id of the status at pageURL if its path looks
+// like one of a Mastodon instance
+func mastodonStatusID(pageURL *url.URL) (string, bool) {
+	match := mastodonPath.FindStringSubmatch(pageURL.Path)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// errMastodonAPI is returned for answers that don't come from the Mastodon API
+var errMastodonAPI = errors.New("mastodon api")
+
+// fetchMastodon unrolls the thread of a status from the public API of its
+// instance, pages of other sites are left to readability
+func fetchMastodon(pageURL *url.URL) (Article, error) {
+	id, ok := mastodonStatusID(pageURL)
+	if !ok {
+		return Article{}, ErrSkipAdapter
+	}
+	api := pageURL.Scheme + "://" + pageURL.Host + "/api/v1/statuses/" + id
+	var status mastodonStatus
+	if err := mastodonGet(api, &status); errors.Is(err, errMastodonAPI) {
+		// Not a Mastodon instance, or a status it doesn't have
+		return Article{}, ErrSkipAdapter
+	} else if err != nil {
+		return Article{}, err
+	}
+	var context mastodonContext
+	if err := mastodonGet(api+"/context", &context); err != nil {
+		return Article{}, err
+	}
+	thread := append(append(context.Ancestors, status), context.Descendants...)
+	article := mastodonArticle(thread, pageURL.String(), discussionOptions)
+	article.SiteName = pageURL.Hostname()
+	return article, nil
+}
+
+func mastodonGet(apiURL string, v any) error {
+	data, status, err := fetchPage(apiURL)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: %s", errMastodonAPI, http.StatusText(status))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s", errMastodonAPI, err)
+	}
+	return nil
+}
+
+// mastodonArticle renders a thread as one article. The posts the author
+// chained to the first one make the body, replies of other people follow in
+// their own section unless only the author's posts are asked for.
+func mastodonArticle(thread []mastodonStatus, source string, opts DiscussionOptions) Article {
+	root := thread[0]
+	chained := map[string]bool{root.ID: true}
+	var content strings.Builder
+	content.WriteString(mastodonPost(root))
+	var others []mastodonStatus
+	for _, post := range thread[1:] {
+		if post.Account.ID == root.Account.ID && chained[post.InReplyToID] {
+			chained[post.ID] = true
+			content.WriteString(mastodonPost(post))
+		} else {
+			others = append(others, post)
+		}
+	}
+
+	if !opts.AuthorOnly && len(others) > 0 {
+		content.WriteString(`<h2>Replies</h2><p class="comment-meta">` + plural(len(others), "reply", "replies") + "</p>")
+		renderComments(&content, mastodonReplies(others, chained), 0, opts)
+	}
+
+	author := mastodonName(root.Account)
+	title := author + " on Mastodon"
+	if summary := collapseSpace(mastodonText(root)); len(summary) > 0 {
+		title += ": " + truncateWords(summary, 60)
+	}
+	article := NewArticle(title, author, content.String(), source)
+	article.Published = root.CreatedAt
+	return article
+}
+
+// mastodonReplies arranges the replies of a thread in a tree, those answering
+// a post of the unrolled thread at the top
+func mastodonReplies(posts []mastodonStatus, chained map[string]bool) []comment {
+	known := make(map[string]bool)
+	children := make(map[string][]mastodonStatus)
+	for _, post := range posts {
+		known[post.ID] = true
+	}
+	var top []mastodonStatus
+	for _, post := range posts {
+		if known[post.InReplyToID] && !chained[post.InReplyToID] {
+			children[post.InReplyToID] = append(children[post.InReplyToID], post)
+		} else {
+			top = append(top, post)
+		}
+	}
+	var build func(posts []mastodonStatus) []comment
+	build = func(posts []mastodonStatus) []comment {
+		var comments []comment
+		for _, post := range posts {
+			comments = append(comments, comment{
+				Author:  mastodonName(post.Account) + " (@" + post.Account.Acct + ")",
+				Body:    mastodonPost(post),
+				Created: post.CreatedAt,
+				Replies: build(children[post.ID]),
+			})
+		}
+		return comments
+	}
+	return build(top)
+}
+
+// mastodonPost writes a status with its content warning and media
+func mastodonPost(post mastodonStatus) string {
+	var out strings.Builder
+	out.WriteString(`<div class="post">`)
+	if len(post.SpoilerText) > 0 {
+		out.WriteString(`<p class="content-warning"><strong>CW:</strong> ` + htmlutil.EscapeString(post.SpoilerText) + "</p>")
+	}
+	out.WriteString(post.Content)
+	for _, media := range post.Media {
+		link := htmlutil.EscapeString(media.URL)
+		description := htmlutil.EscapeString(media.Description)
+		switch media.Type {
+		case "image":
+			out.WriteString(`<figure><img src="` + link + `" alt="` + description + `"/>`)
+			if len(description) > 0 {
+				out.WriteString("<figcaption>" + description + "</figcaption>")
+			}
+			out.WriteString("</figure>")
+		case "gifv", "video":
+			// Readers can't play videos, the preview links to them
+			caption := `<a href="` + link + `">Video</a>`
+			if len(description) > 0 {
+				caption += ": " + description
+			}
+			if len(media.PreviewURL) > 0 {
+				out.WriteString(`<figure><img src="` + htmlutil.EscapeString(media.PreviewURL) + `" alt="` + description + `"/><figcaption>` + caption + "</figcaption></figure>")
+			} else {
+				out.WriteString("<p>" + caption + "</p>")
+			}
+		default:
+			label := "Attachment"
+			if media.Type == "audio" {
+				label = "Audio"
+			}
+			out.WriteString(`<p><a href="` + link + `">` + label + "</a>")
+			if len(description) > 0 {
+				out.WriteString(": " + description)
+			}
+			out.WriteString("</p>")
+		}
+	}
+	out.WriteString("</div>")
+	return out.String()
+}
+
+// mastodonName is the display name of an account, or its username
+func mastodonName(account mastodonAccount) string {
+	if name := strings.TrimSpace(account.DisplayName); len(name) > 0 {
+		return name
+	}
+	return account.Username
+}
+
+// mastodonText is the text of a status, its content warning when it has one
+func mastodonText(post mastodonStatus) string {
+	if len(post.SpoilerText) > 0 {
+		return post.SpoilerText
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(post.Content))
+	if err != nil {
+		return ""
+	}
+	var text []string
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		text = append(text, p.Text())
+	})
+	if len(text) == 0 {
+		return doc.Text()
+	}
+	return strings.Join(text, " ")
+}
@@ -0,0 +1,103 @@
+package epubgen
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMastodonStatusID(t *testing.T) {
+	for path, want := range map[string]string{
+		"/@ada/110":                 "110",
+		"/@ada@example.social/110/": "110",
+		"/users/ada/statuses/110":   "110",
+		"/@ada/with_replies":        "",
+		"/@ada":                     "",
+		"/2024/01/some-post-110":    "",
+	} {
+		id, _ := mastodonStatusID(&url.URL{Path: path})
+		if id != want {
+			t.Errorf("mastodonStatusID(%s) = %q, want %q", path, id, want)
+		}
+	}
+}
+
+const mastodonContextJSON = `{"ancestors":[
+{"id":"1","created_at":"2024-03-05T10:00:00.000Z","content":"<p>A thread about engines 🧵</p>","account":{"id":"a","username":"ada","acct":"ada","display_name":"Ada"}}],
+"descendants":[
+{"id":"3","in_reply_to_id":"2","content":"<p>Nice!</p>","account":{"id":"c","username":"charles","acct":"charles@other.social","display_name":""}},
+{"id":"4","in_reply_to_id":"3","content":"<p>Thanks Charles</p>","account":{"id":"a","username":"ada","acct":"ada","display_name":"Ada"}},
+{"id":"5","in_reply_to_id":"2","content":"<p>Last part.</p>","account":{"id":"a","username":"ada","acct":"ada","display_name":"Ada"},
+ "media_attachments":[{"type":"video","url":"https://files.example/v.mp4","preview_url":"https://files.example/v.png","description":"A working engine"}]}]}`
+
+const mastodonStatusJSON = `{"id":"2","in_reply_to_id":"1","content":"<p>Second part.</p>","account":{"id":"a","username":"ada","acct":"ada","display_name":"Ada"},
+"media_attachments":[{"type":"image","url":"https://files.example/engine.png","description":"The engine"}]}`
+
+func TestFetchMastodon(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/statuses/2":
+			w.Write([]byte(mastodonStatusJSON))
+		case "/api/v1/statuses/2/context":
+			w.Write([]byte(mastodonContextJSON))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer SetDiscussionOptions(discussionOptions)
+
+	pageURL, _ := url.Parse(server.URL + "/@ada/2")
+	article, err := fetchReadable(pageURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Ada on Mastodon: A thread about engines 🧵" || article.Byline != "Ada" || article.Published.Year() != 2024 {
+		t.Errorf("unexpected article %+v", article)
+	}
+	content := article.Content
+	order := []string{"A thread about engines", "Second part.", `<figcaption>The engine</figcaption>`, "Last part.",
+		`<figcaption><a href="https://files.example/v.mp4">Video</a>: A working engine</figcaption>`,
+		"<h2>Replies</h2>", "<strong>charles (@charles@other.social)</strong>", "<p>Nice!</p>", `<blockquote class="comment">`, "Thanks Charles"}
+	last := -1
+	for _, want := range order {
+		idx := strings.Index(content, want)
+		if idx <= last {
+			t.Fatalf("expected %q after the previous parts in %s", want, content)
+		}
+		last = idx
+	}
+
+	SetDiscussionOptions(DiscussionOptions{AuthorOnly: true})
+	article, err = fetchMastodon(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(article.Content, "Replies") || strings.Contains(article.Content, "Thanks Charles") || !strings.Contains(article.Content, "Last part.") {
+		t.Errorf("unexpected author only content %s", article.Content)
+	}
+}
+
+func TestFetchMastodonSkipsOtherSites(t *testing.T) {
+	text := strings.Repeat("A blog post with enough words, and commas, for readability to keep it. ", 6)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><head><title>Post</title></head><body><article><p>` + text + `</p></article></body></html>`))
+	}))
+	defer server.Close()
+
+	pageURL, _ := url.Parse(server.URL + "/@ada/2")
+	if _, err := fetchMastodon(pageURL); !errors.Is(err, ErrSkipAdapter) {
+		t.Errorf("expected the page to be left to readability, got %v", err)
+	}
+	article, err := fetchReadable(pageURL.String())
+	if err != nil || !strings.Contains(article.Content, "A blog post") {
+		t.Errorf("expected readability to extract the page, got %v %+v", err, article)
+	}
+}
@@ -0,0 +1,47 @@
+package epubgen
+
+import (
+	"github.com/nikhil1raghav/kindle-send/config"
+)
+
+// Options tunes how a book is built for the reading device
+type Options struct {
+	Profile config.Profile
+	// Path of an image used as cover instead of the generated one
+	Cover string
+	// Headings listed in the table of contents, 0 lists articles only,
+	// 1 adds their h2 sections, 2 their h3 sections too. EPUB tables of
+	// contents nest one level here, so h3 sections sit next to the h2 ones.
+	TOCDepth int
+	// Reading theme, one of ThemeNames, and a CSS file added after it
+	Theme      string
+	Stylesheet string
+	// Turns external links into endnotes per article or for the whole book,
+	// one of the Endnotes constants
+	Endnotes string
+	// Output format, one of FormatNames
+	Format string
+	// Tags written in the front matter of Markdown notes
+	Tags []string
+	// Page size of PDF books, one of PageSizeNames or WIDTHxHEIGHT in
+	// millimetres, and their font size in points. Empty uses the defaults.
+	PageSize string
+	FontSize float64
+}
+
+// NewOptions returns options for the named device profile, an empty name
+// selects the default profile. Theme and stylesheet come from the config.
+func NewOptions(profile string) (Options, error) {
+	p, err := config.GetProfile(profile)
+	if err != nil {
+		return Options{}, err
+	}
+	opts := Options{Profile: p, Theme: DefaultTheme, Format: FormatEPUB}
+	if c := config.GetInstance(); c != nil {
+		if len(c.Theme) > 0 {
+			opts.Theme = c.Theme
+		}
+		opts.Stylesheet = c.Stylesheet
+	}
+	return opts, nil
+}
@@ -0,0 +1,183 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-shiori/go-readability"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/net/html"
+)
+
+// DefaultPageLimit is how many pages of an article split over several
+// pages are fetched
+const DefaultPageLimit = 10
+
+var pageLimit = DefaultPageLimit
+
+// SetPageLimit sets how many pages of a multi-page article are fetched, 1
+// only fetches the page given. A value below 1 keeps the current limit.
+func SetPageLimit(limit int) {
+	if limit > 0 {
+		pageLimit = limit
+	}
+}
+
+// Links to the next page of an article, in order of trust
+var nextPageSelectors = []string{
+	`link[rel~="next"]`,
+	`a[rel~="next"]`,
+	`a.next-page, a.next_page, a.pagination-next, a.pagination__next, .pagination a.next, .pager-next > a, li.next > a`,
+}
+
+// Text of next page links in pagination blocks without any markup to go by
+var nextPageText = regexp.MustCompile(`(?i)^((next( page)?|weiter|suivant|siguiente)\s*[›»→>]*|[›→])$`)
+
+// Query parameters holding the page number
+var pageParams = []string{"page", "p", "pg"}
+
+// Page numbers at the end of a path: /page/2, /2, story-2.html or
+// story_page_2.html
+var (
+	pageSegment = regexp.MustCompile(`(?i)^(.*)/page/(\d+)$`)
+	pageSuffix  = regexp.MustCompile(`(?i)^(.*?)(?:/|[-_](?:page[-_]?)?)(\d+)(\.[a-z0-9]+)?$`)
+)
+
+// splitPage returns the address of a page without its page number, and the
+// number, 0 when it has none
+func splitPage(u *url.URL) (string, int) {
+	path := strings.TrimSuffix(strings.ToLower(u.Path), "/")
+	query := u.Query()
+	num := 0
+	for _, param := range pageParams {
+		if value := query.Get(param); len(value) > 0 {
+			if n, err := strconv.Atoi(value); err == nil && num == 0 {
+				num = n
+				query.Del(param)
+			}
+		}
+	}
+	if num == 0 {
+		if m := pageSegment.FindStringSubmatch(path); m != nil {
+			path = m[1]
+			num, _ = strconv.Atoi(m[2])
+		} else if m := pageSuffix.FindStringSubmatch(path); m != nil {
+			path = m[1] + m[3]
+			num, _ = strconv.Atoi(m[2])
+		}
+	}
+	return path + "?" + query.Encode(), num
+}
+
+// isNextPage reports whether next looks like page num of the article at
+// current rather than another article of the site, which some sites also
+// mark with rel=next. Only the page number may change, and by one: a
+// following post with a numeric id or the next article of a series isn't a
+// page of the article.
+func isNextPage(current *url.URL, next *url.URL, num int) bool {
+	if next.Scheme != "http" && next.Scheme != "https" {
+		return false
+	}
+	if strings.TrimPrefix(current.Hostname(), "www.") != strings.TrimPrefix(next.Hostname(), "www.") {
+		return false
+	}
+	nextBase, nextNum := splitPage(next)
+	if nextNum != num {
+		return false
+	}
+	// The first page has no page number, or 1
+	currentBase, currentNum := splitPage(current)
+	if num == 2 && currentNum != 1 {
+		currentBase = strings.TrimSuffix(strings.ToLower(current.Path), "/") + "?" + current.Query().Encode()
+	} else if currentNum != num-1 {
+		return false
+	}
+	return currentBase == nextBase
+}
+
+// nextPageURL finds the link to page num of the article in page, the page
+// before it, empty if there is none
+func nextPageURL(page []byte, pageURL *url.URL, num int) string {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return ""
+	}
+	var candidates []*goquery.Selection
+	for _, selector := range nextPageSelectors {
+		candidates = append(candidates, doc.Find(selector))
+	}
+	candidates = append(candidates, doc.Find(`[class*="pag"], [id*="pag"]`).Find("a").FilterFunction(func(_ int, a *goquery.Selection) bool {
+		text := strings.TrimSpace(a.Text())
+		return len(text) > 0 && nextPageText.MatchString(text)
+	}))
+
+	for _, links := range candidates {
+		for _, node := range links.Nodes {
+			link, err := pageURL.Parse(strings.TrimSpace(getAttr(node, "href")))
+			if err != nil {
+				continue
+			}
+			link.Fragment = ""
+			if isNextPage(pageURL, link, num) {
+				return link.String()
+			}
+		}
+	}
+	return ""
+}
+
+// appendPage adds the content of a following page to the article
+func appendPage(article *Article, next readability.Article) {
+	article.TextContent += "\n" + next.TextContent
+	article.Length += next.Length
+	if article.Node == nil || next.Node == nil {
+		article.Content += next.Content
+		return
+	}
+	for c := next.Node.FirstChild; c != nil; c = next.Node.FirstChild {
+		next.Node.RemoveChild(c)
+		article.Node.AppendChild(c)
+	}
+	var content bytes.Buffer
+	if err := html.Render(&content, article.Node); err == nil {
+		article.Content = content.String()
+	}
+}
+
+// followPages fetches the following pages of an article split over several
+// pages and adds their content to it, page is the html of the first page
+func followPages(article *Article, page []byte, pageURL *url.URL) {
+	seen := map[string]bool{pageURL.String(): true}
+	lastText := article.TextContent
+	for num := 2; num <= pageLimit; num++ {
+		next := nextPageURL(page, pageURL, num)
+		if len(next) == 0 || seen[next] {
+			return
+		}
+		seen[next] = true
+
+		nextPage, status, err := fetchPage(next)
+		if err == nil && status != http.StatusOK {
+			err = errors.New(http.StatusText(status))
+		}
+		if err != nil {
+			util.Red.Printf("Couldn't fetch page %d of %s, keeping the pages before : %s\n", num, article.Source, err)
+			return
+		}
+		nextURL, _ := url.Parse(next)
+		readable, err := readability.FromReader(bytes.NewReader(nextPage), nextURL)
+		// Some sites ignore the page number and serve the same page again
+		if err != nil || len(strings.TrimSpace(readable.TextContent)) == 0 || readable.TextContent == lastText {
+			return
+		}
+		util.Cyan.Printf("Added page %d of %s\n", num, article.Title)
+		appendPage(article, readable)
+		page, pageURL, lastText = nextPage, nextURL, readable.TextContent
+	}
+}
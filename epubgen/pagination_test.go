@@ -0,0 +1,81 @@
+package epubgen
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsNextPage(t *testing.T) {
+	tests := []struct {
+		current, next string
+		num           int
+		want          bool
+	}{
+		{"https://www.example.com/news/2024/03/story.html", "https://example.com/news/2024/03/story-2.html", 2, true},
+		{"https://www.example.com/news/2024/03/story-2.html", "https://example.com/news/2024/03/story-3.html", 3, true},
+		{"https://www.example.com/news/2024/03/story.html", "https://www.example.com/news/2024/03/story.html?page=2", 2, true},
+		{"https://example.com/read?id=5&p=2", "https://example.com/read?p=3&id=5", 3, true},
+		{"https://example.com/blog/story/", "https://example.com/blog/story/page/2/", 2, true},
+		{"https://example.com/posts/123", "https://example.com/posts/123/2", 2, true},
+		{"https://www.example.com/news/2024/03/story.html", "https://www.example.com/news/2024/03/story/page/2", 2, false},
+		{"https://www.example.com/news/2024/03/story.html", "https://www.example.com/news/2024/03/other.html", 2, false},
+		{"https://www.example.com/news/2024/03/story.html", "https://other.com/news/2024/03/story-2.html", 2, false},
+		{"https://www.example.com/news/2024/03/story.html", "https://www.example.com/news/2024/03/story.html", 2, false},
+		{"https://www.example.com/news/2024/03/story.html", "https://example.com/news/2024/03/story-5.html", 2, false},
+		// Numeric ids of posts
+		{"https://example.com/posts/123", "https://example.com/posts/124", 2, false},
+		{"https://example.com/2024/03/foo", "https://example.com/2024/04/foo", 2, false},
+		{"https://example.com/story-7.html", "https://example.com/story-8.html", 2, false},
+		// Ids in the query
+		{"https://example.com/read?id=5", "https://example.com/read?id=6", 2, false},
+		{"https://example.com/story", "https://example.com/story?replytocom=42", 2, false},
+		{"https://example.com/read?id=5&page=2", "https://example.com/read?id=6&page=3", 3, false},
+	}
+	for _, test := range tests {
+		current, _ := url.Parse(test.current)
+		next, _ := url.Parse(test.next)
+		if got := isNextPage(current, next, test.num); got != test.want {
+			t.Errorf("isNextPage(%s, %s, %d) = %v, want %v", test.current, test.next, test.num, got, test.want)
+		}
+	}
+}
+
+func TestFetchReadableFollowsPages(t *testing.T) {
+	text := strings.Repeat("This paragraph has enough words, and commas, for readability to keep it. ", 6)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		var next string
+		switch page {
+		case "":
+			next = `<link rel="next" href="/story?page=2">`
+		case "2":
+			next = `<div class="pagination"><a href="/story?page=1">1</a> <a href="/story?page=3">Next »</a></div>`
+		case "3":
+			// Blogs link the next post the same way
+			next = `<link rel="next" href="/another-story">`
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<html><head><title>Story</title>%s</head><body><article><h1>Story</h1><p>Page %s marker. %s</p></article>%s</body></html>`,
+			next, page, text, next)
+	}))
+	defer server.Close()
+
+	article, err := fetchReadable(server.URL + "/story")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Page  marker", "Page 2 marker", "Page 3 marker"} {
+		if !strings.Contains(article.Content, want) || !strings.Contains(article.TextContent, want) {
+			t.Errorf("expected %q in the merged article", want)
+		}
+	}
+	if strings.Count(article.Content, "marker") != 3 {
+		t.Errorf("expected 3 pages in the article, got %s", article.Content)
+	}
+}
@@ -0,0 +1,82 @@
+package epubgen
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gosimple/slug"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// PassThrough is a file sent to the device as it is instead of being
+// converted, like the PDF of a paper without an HTML version
+type PassThrough struct {
+	URL  string
+	Name string // File name it is saved as
+}
+
+// passThroughFile reports whether pageURL is better sent as it is than
+// converted, readability makes a mess of PDFs
+func passThroughFile(pageURL string) (PassThrough, bool) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return PassThrough{}, false
+	}
+	if paper, ok := arxivPDFOnly(u); ok {
+		return paper, true
+	}
+	if strings.HasSuffix(strings.ToLower(u.Path), ".pdf") {
+		return PassThrough{URL: pageURL, Name: pdfName(path.Base(u.Path))}, true
+	}
+	return PassThrough{}, false
+}
+
+// pdfName makes a file name for a PDF from a title or the name in its url
+func pdfName(name string) string {
+	name = slug.Make(strings.TrimSuffix(strings.ToLower(name), ".pdf"))
+	if len(name) == 0 {
+		name = "document"
+	}
+	return name + ".pdf"
+}
+
+// SplitPassThrough separates the urls of files sent as they are from the
+// pages converted into a book
+func SplitPassThrough(pageURLs []string) ([]string, []PassThrough) {
+	var pages []string
+	var files []PassThrough
+	for _, pageURL := range pageURLs {
+		if file, ok := passThroughFile(pageURL); ok {
+			files = append(files, file)
+		} else {
+			pages = append(pages, pageURL)
+		}
+	}
+	return pages, files
+}
+
+// DownloadFile saves a file sent as it is to outputDir, or where books are
+// saved when empty, returns its path
+func DownloadFile(file PassThrough, outputDir string) (string, error) {
+	data, status, err := fetchPage(file.URL)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", errors.New(http.StatusText(status))
+	}
+	if kind := http.DetectContentType(data); path.Ext(file.Name) == ".pdf" && kind != "application/pdf" {
+		return "", fmt.Errorf("expected a PDF, got %s", kind)
+	}
+	filepath := path.Join(storeDir(outputDir), file.Name)
+	if err := os.WriteFile(filepath, data, 0644); err != nil {
+		return "", err
+	}
+	util.Green.Printf("Downloaded %s as it is to %s\n", file.URL, filepath)
+	return filepath, nil
+}
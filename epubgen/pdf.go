@@ -0,0 +1,856 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Page and font size of PDF books when the options leave them empty
+const (
+	DefaultPageSize = "a5"
+	DefaultFontSize = 11.0
+)
+
+// Smallest and largest font size of PDF books, in points
+const (
+	minFontSize = 6.0
+	maxFontSize = 32.0
+)
+
+// PDF layout, sizes relative to the font size
+const (
+	pdfPointsPerMM    = 72 / 25.4
+	pdfPointsPerPixel = 0.75 // Images are laid out at 96 dpi
+	pdfLineSpacing    = 1.35
+	pdfParagraphGap   = 0.5
+	pdfIndent         = 1.5 // Indentation of lists and quotes
+	pdfCodeScale      = 0.85
+	pdfSmallScale     = 0.7 // Superscripts, subscripts and page numbers
+)
+
+// Font sizes of h1 to h6
+var pdfHeadingScale = [...]float64{1, 1.6, 1.35, 1.18, 1.05, 1, 1}
+
+// Page sizes of PDF books in millimetres, the reMarkable ones match the
+// screen of the device
+var pageSizes = map[string][2]float64{
+	"a4":             {210, 297},
+	"a5":             {148, 210},
+	"a6":             {105, 148},
+	"letter":         {215.9, 279.4},
+	"remarkable":     {158, 210}, // reMarkable 1 and 2
+	"remarkable-pro": {180, 240}, // reMarkable Paper Pro
+}
+
+// PageSizeNames returns the named page sizes of PDF books, sorted
+func PageSizeNames() []string {
+	names := make([]string, 0, len(pageSizes))
+	for name := range pageSizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pageSize returns the width and height in points of a named page size or
+// of a size given as WIDTHxHEIGHT in millimetres
+func pageSize(name string) (float64, float64, error) {
+	if len(name) == 0 {
+		name = DefaultPageSize
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	mm, ok := pageSizes[name]
+	if !ok {
+		w, h, found := strings.Cut(name, "x")
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if !found || errW != nil || errH != nil || width < 50 || height < 50 {
+			return 0, 0, fmt.Errorf("unknown page size %q, use one of %v or WIDTHxHEIGHT in millimetres", name, PageSizeNames())
+		}
+		mm = [2]float64{width, height}
+	}
+	return mm[0] * pdfPointsPerMM, mm[1] * pdfPointsPerMM, nil
+}
+
+// pdfSection is an article, or the endnotes of the book, as HTML
+type pdfSection struct {
+	title   string
+	content string
+	// Heading levels listed in the bookmarks
+	tocDepth int
+}
+
+// pdfWriter lays out a book on pages of a fixed size, for readers that
+// show PDF better than EPUB. The Go fonts are embedded so the book looks
+// the same everywhere.
+type pdfWriter struct {
+	title    string
+	opts     Options
+	width    float64 // Page size in points
+	height   float64
+	margin   float64
+	fontSize float64
+	// Regular, bold, italic and monospaced fonts
+	fonts    [4]*pdfFont
+	images   map[string]processedImage
+	cover    string
+	sections []pdfSection
+	// Metadata of single article books
+	author string
+}
+
+func newPDFWriter(title string, opts Options) (*pdfWriter, error) {
+	width, height, err := pageSize(opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	fontSize := opts.FontSize
+	if fontSize == 0 {
+		fontSize = DefaultFontSize
+	}
+	if fontSize < minFontSize || fontSize > maxFontSize {
+		return nil, fmt.Errorf("font size %g is out of range, use %g to %g points", fontSize, minFontSize, maxFontSize)
+	}
+	w := &pdfWriter{
+		title:    title,
+		opts:     opts,
+		width:    width,
+		height:   height,
+		margin:   max(width*0.08, 18),
+		fontSize: fontSize,
+		images:   make(map[string]processedImage),
+	}
+	ttfs := [...][]byte{goregular.TTF, gobold.TTF, goitalic.TTF, gomono.TTF}
+	flags := [...]int{0, 0, pdfItalic, pdfFixedPitch}
+	for i := range ttfs {
+		if w.fonts[i], err = newPDFFont(fmt.Sprintf("F%d", i+1), ttfs[i], flags[i]); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *pdfWriter) addImage(name string, img processedImage) (string, error) {
+	w.images[name] = img
+	return name, nil
+}
+
+func (w *pdfWriter) setCover(name string, img processedImage) error {
+	w.images[name] = img
+	w.cover = name
+	return nil
+}
+
+func (w *pdfWriter) addContent(articles *[]Article) error {
+	if err := checkEndnotes(w.opts.Endnotes); err != nil {
+		return err
+	}
+	if len(*articles) == 0 {
+		return errors.New("No article was added, pdf creation failed")
+	}
+	// PDF pages can't link back to references, so notes only list the links
+	var bookNotes *endnotes
+	if w.opts.Endnotes == EndnotesBook {
+		bookNotes = newEndnotes("", "")
+	}
+	for idx, article := range *articles {
+		notes := bookNotes
+		if w.opts.Endnotes == EndnotesArticle {
+			notes = newEndnotes(fmt.Sprintf("a%d-", idx+1), "")
+		}
+		content := article.Content
+		if notes != nil {
+			content = notes.rewrite(content, "", article.Source)
+		}
+		content = articleHeader(&article) + content
+		if w.opts.Endnotes == EndnotesArticle && !notes.empty() {
+			content += notes.render()
+		}
+		w.sections = append(w.sections, pdfSection{title: article.Title, content: content, tocDepth: tocDepth(&article, w.opts)})
+	}
+	if bookNotes != nil && !bookNotes.empty() {
+		w.sections = append(w.sections, pdfSection{title: endnotesTitle, content: bookNotes.render(), tocDepth: w.opts.TOCDepth})
+	}
+	util.Green.Printf("Added %d articles\n", len(*articles))
+
+	if len(*articles) == 1 {
+		w.author = strings.TrimSpace((*articles)[0].Byline)
+	}
+	return nil
+}
+
+func (w *pdfWriter) write(filepath string) error {
+	l := &pdfLayout{w: w, images: make(map[string]pdfImageRef)}
+	if len(w.cover) > 0 {
+		l.coverPage(w.cover)
+	}
+	for _, section := range w.sections {
+		l.section(section)
+	}
+	l.numberPages()
+
+	data, err := w.render(l)
+	if err != nil {
+		return err
+	}
+	util.Green.Printf("Laid out %d pages\n", len(l.pages))
+	return os.WriteFile(filepath, data, 0644)
+}
+
+// pdfStyle is how a run of text is drawn
+type pdfStyle struct {
+	bold   bool
+	italic bool
+	mono   bool
+	size   float64
+	rise   float64 // Shift of the baseline, for superscripts and subscripts
+}
+
+func (w *pdfWriter) font(style pdfStyle) *pdfFont {
+	switch {
+	case style.mono:
+		return w.fonts[3]
+	case style.bold:
+		return w.fonts[1]
+	case style.italic:
+		return w.fonts[2]
+	}
+	return w.fonts[0]
+}
+
+// pdfWord is a word of a paragraph
+type pdfWord struct {
+	text  string
+	style pdfStyle
+	space bool // White space before the word, lines can break there
+	br    bool // Line break before the word
+}
+
+func (w *pdfWriter) wordsWidth(words []pdfWord) float64 {
+	var width float64
+	for _, word := range words {
+		width += w.font(word.style).width(word.text, word.style.size)
+	}
+	return width
+}
+
+// splitWords breaks words that don't fit a line, like urls, into pieces
+// no wider than width
+func (w *pdfWriter) splitWords(words []pdfWord, width float64) [][]pdfWord {
+	var pieces [][]pdfWord
+	var piece []pdfWord
+	var pieceWidth float64
+	for _, word := range words {
+		font := w.font(word.style)
+		var text strings.Builder
+		for _, r := range word.text {
+			runeWidth := font.width(string(r), word.style.size)
+			if pieceWidth > 0 && pieceWidth+runeWidth > width {
+				if text.Len() > 0 {
+					piece = append(piece, pdfWord{text: text.String(), style: word.style})
+					text.Reset()
+				}
+				pieces = append(pieces, piece)
+				piece, pieceWidth = nil, 0
+			}
+			text.WriteRune(r)
+			pieceWidth += runeWidth
+		}
+		if text.Len() > 0 {
+			piece = append(piece, pdfWord{text: text.String(), style: word.style})
+		}
+	}
+	return append(pieces, piece)
+}
+
+// pdfPage holds the drawing operators of a page
+type pdfPage struct {
+	content  strings.Builder
+	numbered bool
+}
+
+// pdfImageRef is an image drawn on the pages
+type pdfImageRef struct {
+	name   string // Resource name used in page content
+	width  int
+	height int
+}
+
+// pdfOutlineItem is a bookmark, level 0 for articles and 1 or 2 for their
+// headings
+type pdfOutlineItem struct {
+	title string
+	level int
+	page  int
+	top   float64
+}
+
+// pdfLayout flows the HTML of the sections onto pages
+type pdfLayout struct {
+	w     *pdfWriter
+	pages []*pdfPage
+	// Distance from the top of the page to the top of the next line
+	y float64
+	// Left indentation of the open lists and quotes
+	indent float64
+	// Position of the bars of the open quotes
+	quotes []float64
+	// List marker drawn before the next line
+	marker string
+	// Inline content of the open paragraph
+	words []pdfWord
+	space bool
+	br    bool
+	// Images by src, drawn at least once
+	images  map[string]pdfImageRef
+	outline []pdfOutlineItem
+	// Heading levels of the current section listed in the bookmarks
+	tocDepth int
+}
+
+func (l *pdfLayout) page() *pdfPage {
+	return l.pages[len(l.pages)-1]
+}
+
+func (l *pdfLayout) newPage(numbered bool) {
+	l.pages = append(l.pages, &pdfPage{numbered: numbered})
+	l.y = l.w.margin
+}
+
+func (l *pdfLayout) atTop() bool {
+	return len(l.pages) == 0 || l.y <= l.w.margin
+}
+
+func (l *pdfLayout) left() float64 {
+	return l.w.margin + l.indent
+}
+
+func (l *pdfLayout) right() float64 {
+	return l.w.width - l.w.margin
+}
+
+// ensure starts a new page unless height fits on the current one
+func (l *pdfLayout) ensure(height float64) {
+	if !l.atTop() && l.y+height > l.w.height-l.w.margin {
+		l.newPage(true)
+	}
+}
+
+// gap adds vertical space, except at the top of a page
+func (l *pdfLayout) gap(height float64) {
+	if !l.atTop() {
+		l.y += height
+	}
+}
+
+// pdfY turns a distance from the top of the page into a PDF coordinate
+func (l *pdfLayout) pdfY(y float64) float64 {
+	return l.w.height - y
+}
+
+// coverPage draws the cover image as large as the page allows
+func (l *pdfLayout) coverPage(src string) {
+	ref, ok := l.imageRef(src)
+	if !ok {
+		return
+	}
+	l.newPage(false)
+	scale := min(l.w.width/float64(ref.width), l.w.height/float64(ref.height))
+	width, height := float64(ref.width)*scale, float64(ref.height)*scale
+	fmt.Fprintf(&l.page().content, "q %s 0 0 %s %s %s cm /%s Do Q\n", pdfNum(width), pdfNum(height),
+		pdfNum((l.w.width-width)/2), pdfNum((l.w.height-height)/2), ref.name)
+}
+
+// section starts an article on a new page
+func (l *pdfLayout) section(section pdfSection) {
+	l.newPage(true)
+	l.outline = append(l.outline, pdfOutlineItem{title: section.title, page: len(l.pages) - 1, top: l.y})
+	l.tocDepth = section.tocDepth
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(section.content))
+	if err != nil {
+		util.Red.Printf("Couldn't lay out %s : %s\n", section.title, err)
+		return
+	}
+	body := doc.Find("body").Get(0)
+	if body == nil {
+		return
+	}
+	l.blocks(body, pdfStyle{size: l.w.fontSize})
+	l.flush()
+}
+
+// skipped reports whether an element is left out of the PDF, along with
+// the links back to endnote references that pages can't follow
+func skipped(n *html.Node) bool {
+	if n.Type != html.ElementNode || fb2Skip[n.DataAtom] {
+		return true
+	}
+	return n.DataAtom == atom.A && strings.Contains(" "+getAttr(n, "class")+" ", " endnote-back ")
+}
+
+// blocks lays out the children of a block element
+func (l *pdfLayout) blocks(n *html.Node, style pdfStyle) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode:
+			l.text(c.Data, style)
+		case skipped(c):
+		default:
+			if _, inline := fb2Inline[c.DataAtom]; inline || c.DataAtom == atom.Img || c.DataAtom == atom.Br {
+				l.inline(c, style)
+			} else {
+				l.flush()
+				l.block(c, style)
+			}
+		}
+	}
+}
+
+// text adds the words of a text node to the open paragraph
+func (l *pdfLayout) text(data string, style pdfStyle) {
+	text := collapseSpace(data)
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		l.space = l.space || len(text) > 0
+		return
+	}
+	l.space = l.space || strings.HasPrefix(text, " ")
+	for i, word := range words {
+		l.words = append(l.words, pdfWord{text: word, style: style, space: l.space || i > 0, br: l.br})
+		l.br = false
+	}
+	l.space = strings.HasSuffix(text, " ")
+}
+
+func (l *pdfLayout) inline(n *html.Node, style pdfStyle) {
+	switch n.DataAtom {
+	case atom.Img:
+		l.image(n)
+		return
+	case atom.Br:
+		l.br = true
+		return
+	case atom.Strong, atom.B:
+		style.bold = true
+	case atom.Em, atom.I, atom.Cite, atom.Dfn:
+		style.italic = true
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt, atom.Var:
+		if !style.mono {
+			style.mono = true
+			style.size *= pdfCodeScale
+		}
+	case atom.Sup:
+		style.rise += style.size * 0.35
+		style.size *= pdfSmallScale
+	case atom.Sub:
+		style.rise -= style.size * 0.15
+		style.size *= pdfSmallScale
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode:
+			l.text(c.Data, style)
+		case skipped(c):
+		default:
+			l.inline(c, style)
+		}
+	}
+}
+
+func (l *pdfLayout) block(n *html.Node, style pdfStyle) {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		style.bold = true
+		style.size = l.w.fontSize * pdfHeadingScale[headingLevel(n)]
+		l.gap(style.size * 0.6)
+		// Keep the heading with the first lines below it
+		l.ensure((style.size + 2*l.w.fontSize) * pdfLineSpacing)
+		if isSplitHeading(n, l.tocDepth) {
+			if title := strings.TrimSpace(nodeText(n)); len(title) > 0 {
+				l.outline = append(l.outline, pdfOutlineItem{title: title, level: headingLevel(n) - 1, page: len(l.pages) - 1, top: l.y})
+			}
+		}
+		l.blocks(n, style)
+	case atom.Ul, atom.Ol:
+		l.indent += l.w.fontSize * pdfIndent
+		num := 0
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+				continue
+			}
+			num++
+			l.marker = "•"
+			if n.DataAtom == atom.Ol {
+				l.marker = strconv.Itoa(num) + "."
+			}
+			l.blocks(li, style)
+			l.flush()
+			l.marker = ""
+		}
+		l.indent -= l.w.fontSize * pdfIndent
+	case atom.Blockquote:
+		l.quotes = append(l.quotes, l.left())
+		l.indent += l.w.fontSize * pdfIndent
+		l.blocks(n, style)
+		l.flush()
+		l.indent -= l.w.fontSize * pdfIndent
+		l.quotes = l.quotes[:len(l.quotes)-1]
+	case atom.Dd:
+		l.indent += l.w.fontSize * pdfIndent
+		l.blocks(n, style)
+		l.flush()
+		l.indent -= l.w.fontSize * pdfIndent
+	case atom.Pre:
+		l.pre(n, style)
+	case atom.Hr:
+		l.gap(l.w.fontSize * pdfParagraphGap)
+		l.ensure(l.w.fontSize)
+		y := pdfNum(l.pdfY(l.y + l.w.fontSize/2))
+		fmt.Fprintf(&l.page().content, "0.6 G 0.5 w %s %s m %s %s l S 0 G\n", pdfNum(l.left()), y, pdfNum(l.right()), y)
+		l.y += l.w.fontSize * (1 + pdfParagraphGap)
+	case atom.Tr:
+		// Rows are lines of cells, columns would rarely fit the page
+		first := true
+		for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode {
+				continue
+			}
+			if !first {
+				l.words = append(l.words, pdfWord{text: "|", style: style, space: true})
+			}
+			first = false
+			cellStyle := style
+			cellStyle.bold = style.bold || cell.DataAtom == atom.Th
+			l.space = true
+			l.inline(cell, cellStyle)
+		}
+	case atom.Figcaption:
+		style.italic = true
+		style.size *= 0.9
+		l.blocks(n, style)
+	default:
+		l.blocks(n, style)
+	}
+	l.flush()
+}
+
+// flush lays out the open paragraph
+func (l *pdfLayout) flush() {
+	words := l.words
+	l.words, l.space, l.br = nil, false, false
+	if len(words) == 0 {
+		return
+	}
+
+	// Words without space between them stay on the same line
+	var chunks [][]pdfWord
+	for i, word := range words {
+		if i == 0 || word.space || word.br {
+			chunks = append(chunks, nil)
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], word)
+	}
+
+	available := l.right() - l.left()
+	var line []pdfWord
+	var lineWidth float64
+	for _, chunk := range chunks {
+		if chunk[0].br && len(line) > 0 {
+			l.line(line, false)
+			line, lineWidth = nil, 0
+		}
+		width := l.w.wordsWidth(chunk)
+		var space float64
+		if len(line) > 0 && chunk[0].space {
+			space = l.w.font(chunk[0].style).width(" ", chunk[0].style.size)
+		}
+		if len(line) > 0 && lineWidth+space+width > available {
+			l.line(line, false)
+			line, lineWidth, space = nil, 0, 0
+		}
+		if len(line) == 0 && width > available {
+			pieces := l.w.splitWords(chunk, available)
+			for _, piece := range pieces[:len(pieces)-1] {
+				l.line(piece, false)
+			}
+			chunk = pieces[len(pieces)-1]
+			width = l.w.wordsWidth(chunk)
+		}
+		line = append(line, chunk...)
+		lineWidth += space + width
+	}
+	l.line(line, false)
+	l.gap(l.w.fontSize * pdfParagraphGap)
+}
+
+// pre lays out a code block line by line, keeping its spaces
+func (l *pdfLayout) pre(n *html.Node, style pdfStyle) {
+	style.mono = true
+	style.size = l.w.fontSize * pdfCodeScale
+	code := strings.TrimRight(goquery.NewDocumentFromNode(n).Text(), "\n")
+	code = strings.ReplaceAll(code, "\t", "    ")
+	available := l.right() - l.left()
+	for _, text := range strings.Split(code, "\n") {
+		word := pdfWord{text: strings.TrimRight(text, " \r"), style: style}
+		if l.w.wordsWidth([]pdfWord{word}) <= available {
+			l.line([]pdfWord{word}, true)
+			continue
+		}
+		for _, piece := range l.w.splitWords([]pdfWord{word}, available) {
+			l.line(piece, true)
+		}
+	}
+	l.gap(l.w.fontSize * pdfParagraphGap)
+}
+
+// line draws a line of words at the current position, shaded lines are
+// code
+func (l *pdfLayout) line(words []pdfWord, shade bool) {
+	var size float64
+	for _, word := range words {
+		size = max(size, word.style.size)
+	}
+	if size == 0 {
+		return
+	}
+	height := size * pdfLineSpacing
+	if len(l.pages) == 0 {
+		l.newPage(true)
+	}
+	l.ensure(height)
+	out := &l.page().content
+	bottom := pdfNum(l.pdfY(l.y + height))
+	if shade {
+		fmt.Fprintf(out, "0.92 g %s %s %s %s re f 0 g\n", pdfNum(l.left()-size/4), bottom, pdfNum(l.right()-l.left()+size/2), pdfNum(height))
+	}
+	for _, x := range l.quotes {
+		fmt.Fprintf(out, "0.6 g %s %s 1.5 %s re f 0 g\n", pdfNum(x), bottom, pdfNum(height))
+	}
+
+	baseline := l.pdfY(l.y + (height-size)/2 + size*0.8)
+	if len(l.marker) > 0 {
+		marker := pdfWord{text: l.marker, style: pdfStyle{size: l.w.fontSize}}
+		x := l.left() - l.w.wordsWidth([]pdfWord{marker}) - l.w.fontSize*0.4
+		l.draw(out, marker, x, baseline)
+		l.marker = ""
+	}
+	x := l.left()
+	for i, word := range words {
+		font := l.w.font(word.style)
+		if i > 0 && word.space {
+			x += font.width(" ", word.style.size)
+		}
+		l.draw(out, word, x, baseline)
+		x += font.width(word.text, word.style.size)
+	}
+	l.y += height
+}
+
+func (l *pdfLayout) draw(out *strings.Builder, word pdfWord, x float64, baseline float64) {
+	if len(word.text) == 0 {
+		return
+	}
+	font := l.w.font(word.style)
+	fmt.Fprintf(out, "BT /%s %s Tf %s Ts 1 0 0 1 %s %s Tm %s Tj ET\n", font.name, pdfNum(word.style.size),
+		pdfNum(word.style.rise), pdfNum(x), pdfNum(baseline), font.encode(word.text))
+}
+
+// imageRef returns the image stored for src, SVG images can't be drawn
+func (l *pdfLayout) imageRef(src string) (pdfImageRef, bool) {
+	if ref, ok := l.images[src]; ok {
+		return ref, true
+	}
+	img, ok := l.w.images[src]
+	if !ok || img.mediaType == "image/svg+xml" {
+		return pdfImageRef{}, false
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img.data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return pdfImageRef{}, false
+	}
+	ref := pdfImageRef{name: fmt.Sprintf("Im%d", len(l.images)+1), width: cfg.Width, height: cfg.Height}
+	l.images[src] = ref
+	return ref, true
+}
+
+// image draws an image on its own, centred and scaled down to fit the
+// page
+func (l *pdfLayout) image(n *html.Node) {
+	ref, ok := l.imageRef(getAttr(n, "src"))
+	if !ok {
+		return
+	}
+	space, br := l.space, l.br
+	l.flush()
+	l.space, l.br = space, br
+
+	available := l.right() - l.left()
+	width := float64(ref.width) * pdfPointsPerPixel
+	height := float64(ref.height) * pdfPointsPerPixel
+	if width > available {
+		width, height = available, height*available/width
+	}
+	if maxHeight := (l.w.height - 2*l.w.margin) * 0.9; height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	if len(l.pages) == 0 {
+		l.newPage(true)
+	}
+	l.ensure(height)
+	fmt.Fprintf(&l.page().content, "q %s 0 0 %s %s %s cm /%s Do Q\n", pdfNum(width), pdfNum(height),
+		pdfNum(l.left()+(available-width)/2), pdfNum(l.pdfY(l.y+height)), ref.name)
+	l.y += height
+	l.gap(l.w.fontSize * pdfParagraphGap)
+}
+
+// numberPages prints page numbers at the bottom of every page but the cover
+func (l *pdfLayout) numberPages() {
+	style := pdfStyle{size: l.w.fontSize * pdfSmallScale}
+	for i, page := range l.pages {
+		if !page.numbered {
+			continue
+		}
+		number := pdfWord{text: strconv.Itoa(i + 1), style: style}
+		x := (l.w.width - l.w.wordsWidth([]pdfWord{number})) / 2
+		fmt.Fprint(&page.content, "0.4 g\n")
+		l.draw(&page.content, number, x, l.w.margin/2)
+	}
+}
+
+// render writes the pages, fonts, images and outline as a PDF file
+func (w *pdfWriter) render(l *pdfLayout) ([]byte, error) {
+	p := newPDFFile()
+	catalogNum, pagesNum, infoNum, resourcesNum := p.alloc(), p.alloc(), p.alloc(), p.alloc()
+	pageNums := make([]int, len(l.pages))
+	for i := range l.pages {
+		pageNums[i] = p.alloc()
+	}
+
+	var fonts, xobjects strings.Builder
+	for _, f := range w.fonts {
+		if len(f.used) > 0 {
+			fmt.Fprintf(&fonts, "/%s %s ", f.name, pdfRef(f.write(p)))
+		}
+	}
+	srcs := make([]string, 0, len(l.images))
+	for src := range l.images {
+		srcs = append(srcs, src)
+	}
+	sort.Slice(srcs, func(i, j int) bool { return l.images[srcs[i]].name < l.images[srcs[j]].name })
+	for _, src := range srcs {
+		num, err := writeImage(p, w.images[src])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't add image %s to pdf : %w", src, err)
+		}
+		fmt.Fprintf(&xobjects, "/%s %s ", l.images[src].name, pdfRef(num))
+	}
+	p.object(resourcesNum, fmt.Sprintf("<< /Font << %s>> /XObject << %s>> >>", fonts.String(), xobjects.String()))
+
+	mediaBox := fmt.Sprintf("[0 0 %s %s]", pdfNum(w.width), pdfNum(w.height))
+	kids := make([]string, len(l.pages))
+	for i, page := range l.pages {
+		contentNum := p.alloc()
+		p.stream(contentNum, "", "", []byte(page.content.String()))
+		p.object(pageNums[i], fmt.Sprintf("<< /Type /Page /Parent %s /MediaBox %s /Resources %s /Contents %s >>",
+			pdfRef(pagesNum), mediaBox, pdfRef(resourcesNum), pdfRef(contentNum)))
+		kids[i] = pdfRef(pageNums[i])
+	}
+	p.object(pagesNum, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+
+	catalog := "<< /Type /Catalog /Pages " + pdfRef(pagesNum)
+	if outlineNum := w.writeOutline(p, l.outline, pageNums); outlineNum > 0 {
+		catalog += " /Outlines " + pdfRef(outlineNum) + " /PageMode /UseOutlines"
+	}
+	p.object(catalogNum, catalog+" >>")
+
+	info := "<< /Title " + pdfText(w.title) + " /Producer (kindle-send) /CreationDate (D:" + time.Now().UTC().Format("20060102150405") + "Z)"
+	if len(w.author) > 0 {
+		info += " /Author " + pdfText(w.author)
+	}
+	p.object(infoNum, info+" >>")
+	return p.bytes(catalogNum, infoNum), nil
+}
+
+// writeOutline writes the bookmarks of the articles and their headings and
+// returns the number of the outline root, 0 if there is nothing to list
+func (w *pdfWriter) writeOutline(p *pdfFile, items []pdfOutlineItem, pageNums []int) int {
+	if len(items) == 0 {
+		return 0
+	}
+	rootNum := p.alloc()
+	nums := make([]int, len(items))
+	for i := range items {
+		nums[i] = p.alloc()
+	}
+
+	// Headings nest under the closest item of a lower level, -1 is the root
+	parents := make([]int, len(items))
+	children := make(map[int][]int)
+	var open []int
+	for i, item := range items {
+		for len(open) > 0 && items[open[len(open)-1]].level >= item.level {
+			open = open[:len(open)-1]
+		}
+		parent := -1
+		if len(open) > 0 {
+			parent = open[len(open)-1]
+		}
+		parents[i] = parent
+		children[parent] = append(children[parent], i)
+		open = append(open, i)
+	}
+	ref := func(i int) string {
+		if i < 0 {
+			return pdfRef(rootNum)
+		}
+		return pdfRef(nums[i])
+	}
+	// Articles are listed closed, showing their headings on demand
+	kids := func(i int) string {
+		list := children[i]
+		if len(list) == 0 {
+			return ""
+		}
+		count := len(list)
+		if i >= 0 {
+			count = -count
+		}
+		return fmt.Sprintf(" /First %s /Last %s /Count %d", ref(list[0]), ref(list[len(list)-1]), count)
+	}
+
+	for i, item := range items {
+		list := children[parents[i]]
+		pos := 0
+		for list[pos] != i {
+			pos++
+		}
+		dict := fmt.Sprintf("<< /Title %s /Parent %s /Dest [%s /XYZ 0 %s null]", pdfText(item.title), ref(parents[i]),
+			pdfRef(pageNums[item.page]), pdfNum(w.height-item.top))
+		if pos > 0 {
+			dict += " /Prev " + ref(list[pos-1])
+		}
+		if pos < len(list)-1 {
+			dict += " /Next " + ref(list[pos+1])
+		}
+		p.object(nums[i], dict+kids(i)+" >>")
+	}
+	p.object(rootNum, "<< /Type /Outlines"+kids(-1)+" >>")
+	return rootNum
+}
@@ -0,0 +1,56 @@
+package epubgen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPageSize(t *testing.T) {
+	width, height, err := pageSize("150x200")
+	if err != nil || int(width) != 425 || int(height) != 566 {
+		t.Errorf("unexpected size %gx%g : %v", width, height, err)
+	}
+	if _, _, err := pageSize("A4"); err != nil {
+		t.Errorf("named size not found : %s", err)
+	}
+	if _, _, err := pageSize("tiny"); err == nil {
+		t.Error("expected an error for an unknown page size")
+	}
+}
+
+func TestPDFLayoutWrapsWithinMargins(t *testing.T) {
+	w, err := newPDFWriter("Book", Options{PageSize: "a6", FontSize: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &pdfLayout{w: w, images: make(map[string]pdfImageRef)}
+	content := "<h2>Heading</h2><p>" + strings.Repeat("Some words that wrap over many lines. ", 80) + "</p>" +
+		"<p>" + strings.Repeat("x", 200) + "</p><pre><code>func main() {\n\tfmt.Println(\"hi\")\n}</code></pre>"
+	l.section(pdfSection{title: "Article", content: content})
+
+	if len(l.pages) < 3 {
+		t.Fatalf("expected the article to run over several pages, got %d", len(l.pages))
+	}
+	// Every word starts inside the margins
+	for i, page := range l.pages {
+		for _, line := range strings.Split(page.content.String(), "\n") {
+			fields := strings.Fields(line)
+			for j, field := range fields {
+				if field != "Tm" {
+					continue
+				}
+				var x, y float64
+				if _, err := fmt.Sscan(fields[j-2]+" "+fields[j-1], &x, &y); err != nil {
+					t.Fatal(err)
+				}
+				if x < w.margin-0.01 || x > w.width-w.margin || y < w.margin/2 || y > w.height-w.margin {
+					t.Errorf("page %d : text at %g,%g is outside the margins", i+1, x, y)
+				}
+			}
+		}
+	}
+	if len(l.outline) != 1 {
+		t.Errorf("expected only the article in the outline at depth 0, got %v", l.outline)
+	}
+}
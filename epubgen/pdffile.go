@@ -0,0 +1,342 @@
+package epubgen
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfFile collects the objects of a PDF document and writes the cross
+// reference table pointing to them
+type pdfFile struct {
+	out bytes.Buffer
+	// Offset of every object, object n is at index n-1
+	offsets []int
+}
+
+func newPDFFile() *pdfFile {
+	p := &pdfFile{}
+	// The binary comment tells tools the file holds binary data
+	p.out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	return p
+}
+
+// alloc reserves an object number, so objects can refer to each other
+// before they are written
+func (p *pdfFile) alloc() int {
+	p.offsets = append(p.offsets, 0)
+	return len(p.offsets)
+}
+
+func (p *pdfFile) object(num int, body string) {
+	p.offsets[num-1] = p.out.Len()
+	fmt.Fprintf(&p.out, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+// stream writes a stream object, compressed unless filter names the
+// encoding data already has
+func (p *pdfFile) stream(num int, dict string, filter string, data []byte) {
+	if len(filter) == 0 {
+		var buf bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		zw.Write(data)
+		zw.Close()
+		data, filter = buf.Bytes(), "/FlateDecode"
+	}
+	p.offsets[num-1] = p.out.Len()
+	fmt.Fprintf(&p.out, "%d 0 obj\n<< %s /Filter %s /Length %d >>\nstream\n", num, dict, filter, len(data))
+	p.out.Write(data)
+	p.out.WriteString("\nendstream\nendobj\n")
+}
+
+// bytes finishes the document with the catalog root and info dictionary
+func (p *pdfFile) bytes(root int, info int) []byte {
+	xref := p.out.Len()
+	fmt.Fprintf(&p.out, "xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		fmt.Fprintf(&p.out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&p.out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, root, info, xref)
+	return p.out.Bytes()
+}
+
+func pdfRef(num int) string {
+	return strconv.Itoa(num) + " 0 R"
+}
+
+// pdfNum formats a coordinate, two decimals are plenty at 72 points per inch
+func pdfNum(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// pdfText encodes text for the document outline and info as UTF-16
+func pdfText(text string) string {
+	var out strings.Builder
+	out.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&out, "%04X", u)
+	}
+	out.WriteString(">")
+	return out.String()
+}
+
+// pdfGlyph is a glyph of an embedded font
+type pdfGlyph struct {
+	index sfnt.GlyphIndex
+	width float64 // Advance in thousandths of the font size
+}
+
+// pdfFont is a TrueType font embedded in the document. Text is written as
+// glyph ids, so every character the font has can be shown.
+type pdfFont struct {
+	name     string // Resource name used in page content
+	baseName string
+	ttf      []byte
+	font     *sfnt.Font
+	buf      sfnt.Buffer
+	upem     float64
+	flags    int // Font descriptor flags
+	glyphs   map[rune]pdfGlyph
+	// Glyphs shown in the document and the character they stand for
+	used map[sfnt.GlyphIndex]rune
+}
+
+// Font descriptor flags
+const (
+	pdfFixedPitch  = 1
+	pdfNonSymbolic = 32
+	pdfItalic      = 64
+)
+
+func newPDFFont(name string, ttf []byte, flags int) (*pdfFont, error) {
+	f, err := sfnt.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	var buf sfnt.Buffer
+	// PDF names can't hold spaces or delimiters
+	baseName, _ := f.Name(&buf, sfnt.NameIDPostScript)
+	baseName = strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("()<>[]{}/%#", r) {
+			return r
+		}
+		return -1
+	}, baseName)
+	if len(baseName) == 0 {
+		baseName = name
+	}
+	return &pdfFont{
+		name:     name,
+		baseName: baseName,
+		ttf:      ttf,
+		font:     f,
+		upem:     float64(f.UnitsPerEm()),
+		flags:    flags | pdfNonSymbolic,
+		glyphs:   make(map[rune]pdfGlyph),
+		used:     make(map[sfnt.GlyphIndex]rune),
+	}, nil
+}
+
+// ppem asks sfnt for sizes in font units
+func (f *pdfFont) ppem() fixed.Int26_6 {
+	return fixed.Int26_6(f.upem * 64)
+}
+
+// shown reports whether r takes space on the page, format characters like
+// soft hyphens and zero width spaces are dropped
+func shown(r rune) bool {
+	return r >= ' ' && !unicode.Is(unicode.Cf, r)
+}
+
+func (f *pdfFont) glyph(r rune) pdfGlyph {
+	if g, ok := f.glyphs[r]; ok {
+		return g
+	}
+	// Characters missing from the font show as its empty box, glyph 0
+	index, err := f.font.GlyphIndex(&f.buf, r)
+	if err != nil {
+		index = 0
+	}
+	var g pdfGlyph
+	g.index = index
+	if advance, err := f.font.GlyphAdvance(&f.buf, index, f.ppem(), font.HintingNone); err == nil {
+		g.width = float64(advance) / 64 * 1000 / f.upem
+	}
+	f.glyphs[r] = g
+	return g
+}
+
+// width returns the width of text at the given font size
+func (f *pdfFont) width(text string, size float64) float64 {
+	var width float64
+	for _, r := range text {
+		if shown(r) {
+			width += f.glyph(r).width
+		}
+	}
+	return width * size / 1000
+}
+
+// encode returns text as a string of glyph ids for page content
+func (f *pdfFont) encode(text string) string {
+	var out strings.Builder
+	out.WriteString("<")
+	for _, r := range text {
+		if !shown(r) {
+			continue
+		}
+		g := f.glyph(r)
+		if _, ok := f.used[g.index]; !ok {
+			f.used[g.index] = r
+		}
+		fmt.Fprintf(&out, "%04X", uint16(g.index))
+	}
+	out.WriteString(">")
+	return out.String()
+}
+
+// write adds the font to the document and returns its object number. The
+// whole font file is embedded, widths and the map back to characters only
+// list the glyphs used.
+func (f *pdfFont) write(p *pdfFile) int {
+	fontNum, cidNum, descNum, fileNum, cmapNum := p.alloc(), p.alloc(), p.alloc(), p.alloc(), p.alloc()
+
+	indexes := make([]int, 0, len(f.used))
+	for index := range f.used {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+	var widths strings.Builder
+	for _, index := range indexes {
+		width := f.glyph(f.used[sfnt.GlyphIndex(index)]).width
+		fmt.Fprintf(&widths, "%d [%s] ", index, pdfNum(width))
+	}
+
+	bounds, _ := f.font.Bounds(&f.buf, f.ppem(), font.HintingNone)
+	metrics, _ := f.font.Metrics(&f.buf, f.ppem(), font.HintingNone)
+	scale := func(v fixed.Int26_6) string {
+		return pdfNum(float64(v) / 64 * 1000 / f.upem)
+	}
+	italicAngle := 0
+	if f.flags&pdfItalic != 0 {
+		italicAngle = -12
+	}
+
+	p.object(fontNum, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%s] /ToUnicode %s >>",
+		f.baseName, pdfRef(cidNum), pdfRef(cmapNum)))
+	p.object(cidNum, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %s /CIDToGIDMap /Identity /W [%s] >>",
+		f.baseName, pdfRef(descNum), widths.String()))
+	// sfnt measures with y growing downwards
+	p.object(descNum, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%s %s %s %s] /ItalicAngle %d /Ascent %s /Descent -%s /CapHeight %s /StemV 80 /FontFile2 %s >>",
+		f.baseName, f.flags, scale(bounds.Min.X), scale(-bounds.Max.Y), scale(bounds.Max.X), scale(-bounds.Min.Y),
+		italicAngle, scale(metrics.Ascent), scale(metrics.Descent), scale(metrics.CapHeight), pdfRef(fileNum)))
+	p.stream(fileNum, fmt.Sprintf("/Length1 %d", len(f.ttf)), "", f.ttf)
+	p.stream(cmapNum, "", "", f.toUnicode(indexes))
+	return fontNum
+}
+
+// toUnicode maps glyphs back to characters, so text can be searched and copied
+func (f *pdfFont) toUnicode(indexes []int) []byte {
+	var out strings.Builder
+	out.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	out.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	out.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	out.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// A bfchar block holds at most 100 entries
+	for start := 0; start < len(indexes); start += 100 {
+		chunk := indexes[start:min(start+100, len(indexes))]
+		fmt.Fprintf(&out, "%d beginbfchar\n", len(chunk))
+		for _, index := range chunk {
+			fmt.Fprintf(&out, "<%04X> <", index)
+			for _, u := range utf16.Encode([]rune{f.used[sfnt.GlyphIndex(index)]}) {
+				fmt.Fprintf(&out, "%04X", u)
+			}
+			out.WriteString(">\n")
+		}
+		out.WriteString("endbfchar\n")
+	}
+	out.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return []byte(out.String())
+}
+
+// writeImage adds an image as an XObject and returns its object number.
+// JPEGs are embedded as they are, PNGs are decoded to raw pixels.
+func writeImage(p *pdfFile, img processedImage) (int, error) {
+	num := p.alloc()
+	if img.mediaType == "image/jpeg" {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(img.data))
+		if err != nil {
+			return 0, err
+		}
+		colorSpace := "/DeviceRGB"
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			// Adobe writes CMYK JPEGs inverted
+			colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+		}
+		p.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8",
+			cfg.Width, cfg.Height, colorSpace), "/DCTDecode", img.data)
+		return num, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img.data))
+	if err != nil {
+		return 0, err
+	}
+	if !isOpaque(decoded) {
+		decoded = flatten(decoded)
+	}
+	bounds := decoded.Bounds()
+	_, gray := decoded.(*image.Gray)
+	if paletted, ok := decoded.(*image.Paletted); ok {
+		gray = isGrayPalette(paletted.Palette)
+	}
+	colorSpace, channels := "/DeviceRGB", 3
+	if gray {
+		colorSpace, channels = "/DeviceGray", 1
+	}
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*channels)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if gray {
+				pixels = append(pixels, color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y)
+				continue
+			}
+			r, g, b, _ := decoded.At(x, y).RGBA()
+			pixels = append(pixels, byte(r>>8), byte(g>>8), byte(b>>8))
+		}
+	}
+	p.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8",
+		bounds.Dx(), bounds.Dy(), colorSpace), "", pixels)
+	return num, nil
+}
+
+// isGrayPalette reports whether all colours of a palette are grays, as
+// written by the e-ink stage
+func isGrayPalette(palette color.Palette) bool {
+	for _, c := range palette {
+		r, g, b, _ := c.RGBA()
+		if r != g || g != b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"errors"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterAdapter("reddit.com", AdapterFunc(fetchReddit))
+	RegisterAdapter("redd.it", AdapterFunc(fetchReddit))
+}
+
+// Threads are fetched from the json listing of the post, the pages need
+// scripts and hide most comments
+var redditAPI = "https://www.reddit.com/comments/"
+
+var (
+	redditPath  = regexp.MustCompile(`^(?:/r/[^/]+)?/comments/([a-z0-9]+)`)
+	redditShort = regexp.MustCompile(`^/([a-z0-9]+)/?$`)
+)
+
+// redditListing is a page of posts or comments
+type redditListing struct {
+	Data struct {
+		Children []redditThing `json:"children"`
+	} `json:"data"`
+}
+
+// redditThing is a post (t3), a comment (t1), or more comments to load
+type redditThing struct {
+	Kind string `json:"kind"`
+	Data struct {
+		Title        string          `json:"title"`
+		Author       string          `json:"author"`
+		URL          string          `json:"url"`
+		IsSelf       bool            `json:"is_self"`
+		SelftextHTML string          `json:"selftext_html"`
+		BodyHTML     string          `json:"body_html"`
+		Score        int             `json:"score"`
+		Created      float64         `json:"created_utc"`
+		Replies      json.RawMessage `json:"replies"`
+		Count        int             `json:"count"`
+	} `json:"data"`
+}
+
+// fetchReddit turns a post into its thread, other pages of the site are left
+// to readability
+func fetchReddit(pageURL *url.URL) (Article, error) {
+	var match []string
+	if strings.HasSuffix(strings.ToLower(pageURL.Hostname()), "redd.it") {
+		match = redditShort.FindStringSubmatch(pageURL.Path)
+	} else {
+		match = redditPath.FindStringSubmatch(pageURL.Path)
+	}
+	if match == nil {
+		return Article{}, ErrSkipAdapter
+	}
+	data, status, err := fetchPage(redditAPI + match[1] + ".json?raw_json=1&limit=500")
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("reddit: " + http.StatusText(status))
+	}
+	d, err := redditDiscussion(data)
+	if err != nil {
+		return Article{}, err
+	}
+	return discussionArticle(d, pageURL.String(), discussionOptions), nil
+}
+
+// redditDiscussion converts the listings of a post, the post then its
+// comments, into a thread
+func redditDiscussion(data []byte) (discussion, error) {
+	var listings []redditListing
+	if err := json.Unmarshal(data, &listings); err != nil {
+		return discussion{}, err
+	}
+	if len(listings) < 2 || len(listings[0].Data.Children) == 0 {
+		return discussion{}, errors.New("no post in the reddit listing")
+	}
+	post := listings[0].Data.Children[0].Data
+	d := discussion{
+		Title:    post.Title,
+		Author:   post.Author,
+		Body:     redditHTML(post.SelftextHTML),
+		Score:    post.Score,
+		HasScore: true,
+		Created:  time.Unix(int64(post.Created), 0).UTC(),
+	}
+	if !post.IsSelf {
+		d.Link = post.URL
+	}
+	d.Comments, d.More = redditComments(listings[1].Data.Children)
+	return d, nil
+}
+
+// redditComments converts comments, also returning how many more replies
+// are left to load
+func redditComments(things []redditThing) ([]comment, int) {
+	var comments []comment
+	more := 0
+	for _, thing := range things {
+		switch thing.Kind {
+		case "more":
+			more += thing.Data.Count
+		case "t1":
+			c := comment{
+				Author:   thing.Data.Author,
+				Body:     redditHTML(thing.Data.BodyHTML),
+				Score:    thing.Data.Score,
+				HasScore: true,
+				Created:  time.Unix(int64(thing.Data.Created), 0).UTC(),
+			}
+			// Replies are an empty string when there are none
+			var replies redditListing
+			if json.Unmarshal(thing.Data.Replies, &replies) == nil {
+				c.Replies, c.More = redditComments(replies.Data.Children)
+			}
+			comments = append(comments, c)
+		}
+	}
+	return comments, more
+}
+
+// redditHTML returns the markup of a post or comment, escaped unless asked
+// for raw
+func redditHTML(text string) string {
+	if strings.HasPrefix(text, "&lt;") {
+		return htmlutil.UnescapeString(text)
+	}
+	return text
+}
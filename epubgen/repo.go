@@ -0,0 +1,461 @@
+package epubgen
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+var githubArchive = "https://github.com/%s/%s/archive/%s.tar.gz"
+
+// Repository pages, optionally a branch and a directory of it
+var githubRepoPath = regexp.MustCompile(`^/([^/]+)/([^/]+?)(?:\.git)?(?:/tree/([^/]+)(/.*)?)?/?$`)
+
+// First path segments of github.com that aren't users or organisations
+var githubReserved = map[string]bool{
+	"about": true, "collections": true, "explore": true, "features": true, "login": true, "marketplace": true,
+	"orgs": true, "pricing": true, "search": true, "settings": true, "sponsors": true, "topics": true, "trending": true,
+}
+
+// Directories that don't hold the docs of a project
+var repoSkipDirs = map[string]bool{"node_modules": true, "vendor": true, "testdata": true, "third_party": true}
+
+// Largest file taken from a repository archive
+const maxRepoFile = 20 << 20
+
+// docsRepo is a repository whose docs are made into a book
+type docsRepo struct {
+	root  string // Local directory of the docs
+	title string
+	web   string // Where files are browsed on GitHub, empty for local clones
+}
+
+// IsRepo reports whether source is a GitHub repository url, or the path of
+// a local directory with Markdown docs
+func IsRepo(source string) bool {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		_, ok := githubRepo(source)
+		return ok
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	fsys := os.DirFS(source)
+	if len(findReadme(fsys, ".")) > 0 {
+		return true
+	}
+	for _, name := range []string{"docs", "mkdocs.yml", "SUMMARY.md"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// githubRepo returns the owner, name, branch and directory of a repository url
+func githubRepo(source string) ([]string, bool) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Hostname() != "github.com" && u.Hostname() != "www.github.com") {
+		return nil, false
+	}
+	match := githubRepoPath.FindStringSubmatch(u.Path)
+	if match == nil || githubReserved[match[1]] {
+		return nil, false
+	}
+	return match[1:], true
+}
+
+// MakeFromRepo builds a book from the README and Markdown docs of a
+// repository, source is a GitHub url or the path of a local clone
+func MakeFromRepo(source string, outputDir string, opts Options) (string, error) {
+	var repo docsRepo
+	if parts, ok := githubRepo(source); ok {
+		dir, err := os.MkdirTemp("", "kindle-send-repo-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		if repo, err = downloadRepo(parts[0], parts[1], parts[2], strings.Trim(parts[3], "/"), dir); err != nil {
+			return "", err
+		}
+	} else {
+		root, err := filepath.Abs(source)
+		if err != nil {
+			return "", err
+		}
+		repo = docsRepo{root: root, title: filepath.Base(root)}
+	}
+
+	fsys := os.DirFS(repo.root)
+	docs := repoDocs(fsys)
+	if len(docs) == 0 {
+		return "", fmt.Errorf("no Markdown docs in %s", source)
+	}
+	var articles []Article
+	for _, doc := range docs {
+		article, err := repo.article(fsys, doc)
+		if err != nil {
+			util.Red.Printf("Couldn't read %s : %s\n", doc, err)
+			continue
+		}
+		util.Green.Printf("Added %s\n", doc)
+		articles = append(articles, article)
+	}
+	if len(articles) == 0 {
+		return "", fmt.Errorf("no readable docs in %s", source)
+	}
+	return makeBook(articles, repo.title, outputDir, opts)
+}
+
+// downloadRepo extracts the docs and images of a GitHub repository to dir,
+// the default branch is taken when ref is empty
+func downloadRepo(owner string, name string, ref string, subdir string, dir string) (docsRepo, error) {
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+	util.CyanBold.Printf("Downloading %s/%s\n", owner, name)
+	resp, err := getHTTPClient().Get(fmt.Sprintf(githubArchive, owner, name, url.PathEscape(ref)))
+	if err != nil {
+		return docsRepo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return docsRepo{}, errors.New("github: " + resp.Status)
+	}
+	if err := extractDocs(resp.Body, dir); err != nil {
+		return docsRepo{}, err
+	}
+	web := "https://github.com/" + owner + "/" + name + "/blob/" + ref + "/"
+	if len(subdir) > 0 {
+		web += subdir + "/"
+	}
+	return docsRepo{root: filepath.Join(dir, filepath.FromSlash(subdir)), title: name, web: web}, nil
+}
+
+// isRepoFile reports whether a file of a repository is needed for its docs
+func isRepoFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp":
+		return true
+	}
+	base := path.Base(name)
+	return base == "mkdocs.yml" || base == "mkdocs.yaml"
+}
+
+// extractDocs writes the files of a repository archive its docs need to dir
+func extractDocs(archive io.Reader, dir string) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Files are under a directory named after the repository and branch
+		_, name, ok := strings.Cut(header.Name, "/")
+		if !ok || header.Typeflag != tar.TypeReg || header.Size > maxRepoFile || !isRepoFile(name) || !fs.ValidPath(name) {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// findReadme returns the README of a directory, empty if it has none
+func findReadme(fsys fs.FS, dir string) string {
+	entries, _ := fs.ReadDir(fsys, dir)
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if !entry.IsDir() && isMarkdown(name) && strings.TrimSuffix(name, path.Ext(name)) == "readme" {
+			return path.Join(dir, entry.Name())
+		}
+	}
+	return ""
+}
+
+// repoDocs lists the Markdown files of a repository in reading order: the
+// README, then the pages of the mkdocs nav or of a SUMMARY.md, else the docs
+// directory walked a directory at a time
+func repoDocs(fsys fs.FS) []string {
+	var docs []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = path.Clean(name)
+		if seen[name] || !isMarkdown(name) || !fs.ValidPath(name) {
+			return
+		}
+		if _, err := fs.Stat(fsys, name); err == nil {
+			seen[name] = true
+			docs = append(docs, name)
+		}
+	}
+
+	if readme := findReadme(fsys, "."); len(readme) > 0 {
+		add(readme)
+	}
+	if docsDir, nav := mkdocsNav(fsys); len(nav) > 0 {
+		for _, page := range nav {
+			add(path.Join(docsDir, page))
+		}
+		return docs
+	}
+	for _, summary := range []string{"SUMMARY.md", "docs/SUMMARY.md", "src/SUMMARY.md"} {
+		if pages := summaryPages(fsys, summary); len(pages) > 0 {
+			for _, page := range pages {
+				add(path.Join(path.Dir(summary), page))
+			}
+			return docs
+		}
+	}
+	root := "."
+	for _, dir := range []string{"docs", "doc", "documentation"} {
+		if info, err := fs.Stat(fsys, dir); err == nil && info.IsDir() {
+			root = dir
+			break
+		}
+	}
+	walkDocs(fsys, root, add)
+	return docs
+}
+
+// walkDocs adds the Markdown files of a directory, its index or README
+// first, then those of its subdirectories
+func walkDocs(fsys fs.FS, dir string, add func(string)) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return
+	}
+	for _, index := range []string{"index.md", "README.md", "readme.md"} {
+		add(path.Join(dir, index))
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			add(path.Join(dir, entry.Name()))
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && !repoSkipDirs[entry.Name()] {
+			walkDocs(fsys, path.Join(dir, entry.Name()), add)
+		}
+	}
+}
+
+var (
+	mkdocsDocsDir = regexp.MustCompile(`(?m)^docs_dir:\s*['"]?([^'"\s#]+)`)
+	mkdocsPage    = regexp.MustCompile(`([^\s:'"]+\.md)['"]?\s*$`)
+	summaryLink   = regexp.MustCompile(`\]\(<?([^)#\s>]+\.md)`)
+)
+
+// mkdocsNav returns the docs directory and the pages of the nav of an
+// mkdocs.yml, in order
+func mkdocsNav(fsys fs.FS) (string, []string) {
+	data, err := fs.ReadFile(fsys, "mkdocs.yml")
+	if err != nil {
+		if data, err = fs.ReadFile(fsys, "mkdocs.yaml"); err != nil {
+			return "", nil
+		}
+	}
+	docsDir := "docs"
+	if match := mkdocsDocsDir.FindSubmatch(data); match != nil {
+		docsDir = string(match[1])
+	}
+	var pages []string
+	inNav := false
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(line, "nav:") {
+			inNav = true
+			continue
+		}
+		if !inNav || len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// The nav ends at the next top level key
+		if !unicode.IsSpace(rune(line[0])) && !strings.HasPrefix(line, "-") {
+			break
+		}
+		if match := mkdocsPage.FindStringSubmatch(trimmed); match != nil && !strings.Contains(trimmed, "://") {
+			pages = append(pages, match[1])
+		}
+	}
+	return docsDir, pages
+}
+
+// summaryPages returns the pages a SUMMARY.md of mdBook or GitBook links, in order
+func summaryPages(fsys fs.FS, name string) []string {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	var pages []string
+	for _, match := range summaryLink.FindAllSubmatch(data, -1) {
+		if page, err := url.PathUnescape(string(match[1])); err == nil {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+// article renders a Markdown file of the repository. Its images are read
+// from the repository, links to other files point to GitHub, or are dropped
+// for local clones.
+func (repo docsRepo) article(fsys fs.FS, name string) (Article, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Article{}, err
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(markdownToHTML(string(data))))
+	if err != nil {
+		return Article{}, err
+	}
+	body := doc.Find("body")
+
+	// The header of the chapter has the title
+	var title string
+	if first := body.Children().First(); goquery.NodeName(first) == "h1" {
+		title = strings.TrimSpace(first.Text())
+		first.Remove()
+	} else {
+		title = repo.fileTitle(name)
+	}
+
+	body.Find("img[src]").Each(func(_ int, img *goquery.Selection) {
+		if local, ok := repo.localFile(name, img.AttrOr("src", "")); ok {
+			if src, err := repo.inlineImage(local); err != nil {
+				util.Red.Printf("Couldn't read image %s : %s\n", local, err)
+			} else {
+				img.SetAttr("src", src)
+			}
+		}
+	})
+	body.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href := a.AttrOr("href", "")
+		target, err := url.Parse(href)
+		if err != nil || target.IsAbs() || len(target.Host) > 0 || strings.HasPrefix(href, "#") {
+			return
+		}
+		if len(repo.web) == 0 {
+			a.ReplaceWithSelection(a.Contents())
+			return
+		}
+		base := repo.web + name
+		if strings.HasPrefix(target.Path, "/") {
+			base, target.Path = repo.web, "."+target.Path
+		}
+		if link, err := url.Parse(base); err == nil {
+			a.SetAttr("href", link.ResolveReference(target).String())
+		}
+	})
+
+	content, err := body.Html()
+	if err != nil {
+		return Article{}, err
+	}
+	source := ""
+	if len(repo.web) > 0 {
+		source = repo.web + name
+	}
+	article := NewArticle(title, "", content, source)
+	article.SiteName = repo.title
+	return article, nil
+}
+
+// fileTitle makes a title from the name of a document without a heading,
+// index pages are named after their directory
+func (repo docsRepo) fileTitle(name string) string {
+	title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if strings.EqualFold(title, "readme") || strings.EqualFold(title, "index") {
+		if dir := path.Dir(name); dir != "." {
+			title = path.Base(dir)
+		} else {
+			return repo.title
+		}
+	}
+	title = strings.TrimSpace(strings.NewReplacer("-", " ", "_", " ").Replace(title))
+	if len(title) == 0 {
+		return path.Base(name)
+	}
+	runes := []rune(title)
+	return string(unicode.ToUpper(runes[0])) + string(runes[1:])
+}
+
+// inlineImage reads an image of the repository as a data url, the image
+// store picks it up from there like any other. Symlinks leading out of the
+// repository are refused.
+func (repo docsRepo) inlineImage(local string) (string, error) {
+	root, err := filepath.EvalSymlinks(repo.root)
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.EvalSymlinks(local)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("the file is out of the repository")
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return "", err
+	}
+	return dataURL(sniffImage(data), data), nil
+}
+
+// localFile returns the path of a file a document refers to, relative to the
+// document or to the repository when starting with /
+func (repo docsRepo) localFile(doc string, ref string) (string, bool) {
+	target, err := url.Parse(ref)
+	if err != nil || target.IsAbs() || len(target.Host) > 0 || len(target.Path) == 0 {
+		return "", false
+	}
+	name := path.Join(path.Dir(doc), target.Path)
+	if strings.HasPrefix(target.Path, "/") {
+		name = strings.TrimPrefix(target.Path, "/")
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	local := filepath.Join(repo.root, filepath.FromSlash(name))
+	if _, err := os.Stat(local); err != nil {
+		return "", false
+	}
+	return local, true
+}
@@ -0,0 +1,166 @@
+package epubgen
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRepoDocs(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("# Page\n")}
+	walked := fstest.MapFS{
+		"README.md": file, "CHANGELOG.md": file,
+		"docs/b.md": file, "docs/a.md": file, "docs/index.md": file, "docs/sub/c.md": file,
+		"docs/node_modules/x.md": file, "docs/.hidden/y.md": file, "docs/.draft.md": file, ".md": file, "docs/logo.png": file,
+	}
+	nav := fstest.MapFS{
+		"Readme.md": file, "site/intro.md": file, "site/guide/setup.md": file, "site/unlisted.md": file,
+		"mkdocs.yml": {Data: []byte("site_name: X\ndocs_dir: site\nnav:\n  - Home: intro.md\n  - Guide:\n      - 'Setup': 'guide/setup.md'\n  - Issues: https://example.com/issues.md\ntheme: material\n")},
+	}
+	summary := fstest.MapFS{
+		"README.md": file, "src/one.md": file, "src/two.md": file,
+		"src/SUMMARY.md": {Data: []byte("# Summary\n\n- [Two](two.md)\n  - [One](./one.md#top)\n- [Missing](gone.md)\n")},
+	}
+	for name, c := range map[string]struct {
+		fsys fstest.MapFS
+		want string
+	}{
+		"walked":  {walked, "README.md docs/index.md docs/a.md docs/b.md docs/sub/c.md"},
+		"mkdocs":  {nav, "Readme.md site/intro.md site/guide/setup.md"},
+		"summary": {summary, "README.md src/two.md src/one.md"},
+	} {
+		if got := strings.Join(repoDocs(c.fsys), " "); got != c.want {
+			t.Errorf("%s : got %s, want %s", name, got, c.want)
+		}
+	}
+}
+
+func TestRepoFileTitle(t *testing.T) {
+	repo := docsRepo{title: "Project"}
+	for name, want := range map[string]string{
+		"getting-started.md":  "Getting started",
+		"docs/api_ref.md":     "Api ref",
+		"README.md":           "Project",
+		"docs/setup/index.md": "Setup",
+		".md":                 ".md",
+		"docs/-.md":           "-.md",
+	} {
+		if got := repo.fileTitle(name); got != want {
+			t.Errorf("%s : got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractDocs(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string]string{
+		"repo-main/README.md":      "# Repo",
+		"repo-main/docs/img.svg":   "<svg/>",
+		"repo-main/main.go":        "package main",
+		"repo-main/../escape.md":   "no",
+		"repo-main/docs/mkdocs.md": "# Docs",
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	gz.Close()
+
+	dir := t.TempDir()
+	if err := extractDocs(&archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"README.md": true, "docs/img.svg": true, "docs/mkdocs.md": true, "main.go": false} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s extracted : %v, want %v", name, err == nil, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.md")); err == nil {
+		t.Errorf("a file was written out of the directory")
+	}
+}
+
+func TestMakeFromRepo(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "project")
+	os.MkdirAll(filepath.Join(root, "docs", "images"), 0755)
+	os.WriteFile(filepath.Join(root, "README.md"), []byte("# Project\n\nRead the [guide](docs/guide.md).\n"), 0644)
+	os.WriteFile(filepath.Join(root, "docs", "guide.md"), []byte("Setup\n=====\n\n![Diagram](images/diagram.png)\n\n## Install\n\nRun `make`.\n"), 0644)
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)))
+	os.WriteFile(filepath.Join(root, "docs", "images", "diagram.png"), img.Bytes(), 0644)
+
+	if !IsRepo(root) || IsRepo(filepath.Join(root, "docs", "guide.md")) {
+		t.Fatalf("expected only the directory to be a repository")
+	}
+	opts, err := NewOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Format = FormatHTML
+	out := t.TempDir()
+	book, err := MakeFromRepo(root, out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	if filepath.Base(book) != "project.html" {
+		t.Errorf("unexpected book %s", book)
+	}
+	for _, want := range []string{"Read the guide.", "Setup", `<h2 id="install">Install</h2>`, `src="data:image/`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in the book", want)
+		}
+	}
+	if strings.Index(html, "Read the guide") > strings.Index(html, "Run <code>make</code>") {
+		t.Errorf("expected the README before the docs")
+	}
+}
+
+func TestRepoImagesStayInRepo(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	os.MkdirAll(root, 0755)
+	os.WriteFile(filepath.Join(dir, "secret.png"), []byte("not for the book"), 0644)
+	if err := os.Symlink(filepath.Join(dir, "secret.png"), filepath.Join(root, "logo.png")); err != nil {
+		t.Skip("symlinks not supported :", err)
+	}
+	os.WriteFile(filepath.Join(root, "README.md"), []byte("# Project\n\n![Logo](logo.png) ![Up](../secret.png)\n"), 0644)
+
+	repo := docsRepo{root: root, title: "project"}
+	article, err := repo.article(os.DirFS(root), "README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(article.Content, "data:") || strings.Contains(article.Content, "file:") {
+		t.Errorf("expected the images out of the repository to be left out, got %s", article.Content)
+	}
+}
+
+func TestGithubRepo(t *testing.T) {
+	for source, want := range map[string]string{
+		"https://github.com/spf13/cobra":                     "spf13 cobra  ",
+		"https://github.com/spf13/cobra.git":                 "spf13 cobra  ",
+		"https://github.com/golang/go/tree/master/doc":       "golang go master /doc",
+		"https://github.com/spf13/cobra/blob/main/README.md": "",
+		"https://github.com/topics/go":                       "",
+		"https://gitlab.com/a/b":                             "",
+	} {
+		parts, _ := githubRepo(source)
+		if got := strings.Join(parts, " "); got != want {
+			t.Errorf("githubRepo(%s) = %q, want %q", source, got, want)
+		}
+	}
+}
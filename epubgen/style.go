@@ -0,0 +1,72 @@
+package epubgen
+
+import (
+	"embed"
+	"fmt"
+	"os"
+)
+
+//go:embed styles/*.css
+var styles embed.FS
+
+const DefaultTheme = "serif"
+
+// Themes shipped in the binary, each one is added after styles/base.css
+var themes = []string{"serif", "sans", "compact"}
+
+// ThemeNames returns the names of the built-in reading themes
+func ThemeNames() []string {
+	return append([]string(nil), themes...)
+}
+
+func isTheme(name string) bool {
+	for _, theme := range themes {
+		if theme == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stylesheet returns the base stylesheet with the given theme, followed by
+// the custom stylesheet if there is one so its rules win
+func stylesheet(theme string, custom string) ([]byte, error) {
+	if len(theme) == 0 {
+		theme = DefaultTheme
+	}
+	if !isTheme(theme) {
+		return nil, fmt.Errorf("unknown theme %q, available themes are %v", theme, themes)
+	}
+	css, err := styles.ReadFile("styles/base.css")
+	if err != nil {
+		return nil, err
+	}
+	themeCSS, err := styles.ReadFile("styles/" + theme + ".css")
+	if err != nil {
+		return nil, err
+	}
+	css = append(append(css, '\n'), themeCSS...)
+
+	if len(custom) > 0 {
+		customCSS, err := os.ReadFile(custom)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read stylesheet %s : %w", custom, err)
+		}
+		css = append(append(css, '\n'), customCSS...)
+	}
+	return css, nil
+}
+
+// addStylesheet adds the stylesheet of the book, every section links to it
+func (e *epubmaker) addStylesheet() error {
+	css, err := stylesheet(e.opts.Theme, e.opts.Stylesheet)
+	if err != nil {
+		return err
+	}
+	cssRef, err := e.Epub.AddCSS(dataURL("text/css", css), "style.css")
+	if err != nil {
+		return err
+	}
+	e.css = cssRef
+	return nil
+}
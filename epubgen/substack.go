@@ -0,0 +1,252 @@
+package epubgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+func init() {
+	RegisterAdapter("*.substack.com", AdapterFunc(fetchSubstack))
+}
+
+// SubstackArchive picks the posts of a publication's archive and how they
+// are bound into books
+type SubstackArchive struct {
+	Since   time.Time // Posts published before are left out, zero for no limit
+	Until   time.Time // Posts published at or after are left out, zero for no limit
+	PerYear bool      // One book per year instead of one for the whole archive
+}
+
+var substackPostPath = regexp.MustCompile(`^/p/([\w-]+)/?$`)
+
+// Posts per request of the archive api, the most it returns
+const substackPageSize = 50
+
+// Parts of post bodies asking to subscribe or share
+const substackJunk = `script, .subscription-widget-wrap, .subscription-widget-wrap-editor, .subscribe-widget, .button-wrapper, .captioned-button-wrap, .paywall-jump, .image-link-expand`
+
+// substackPost is a post of the archive and posts apis, only the latter
+// has its body
+type substackPost struct {
+	Slug          string    `json:"slug"`
+	Type          string    `json:"type"`
+	Title         string    `json:"title"`
+	Subtitle      string    `json:"subtitle"`
+	PostDate      time.Time `json:"post_date"`
+	CanonicalURL  string    `json:"canonical_url"`
+	BodyHTML      string    `json:"body_html"`
+	TruncatedBody string    `json:"truncated_body_text"`
+	CoverImage    string    `json:"cover_image"`
+	Bylines       []struct {
+		Name string `json:"name"`
+	} `json:"publishedBylines"`
+}
+
+// substackBase returns the address of the publication at pubURL, which can
+// be a substack.com subdomain or a custom domain
+func substackBase(pubURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(pubURL))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", fmt.Errorf("%s is not the url of a publication", pubURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+func substackGet(apiURL string, v any) error {
+	data, status, err := fetchPage(apiURL)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return errors.New("substack api: " + http.StatusText(status))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("substack api: %w", err)
+	}
+	return nil
+}
+
+// substackArchivePosts pages through the archive, newest first, until the
+// posts are older than the range, returns them oldest first
+func substackArchivePosts(base string, archive SubstackArchive) ([]substackPost, error) {
+	var posts []substackPost
+	seen := make(map[string]bool)
+	for offset := 0; ; {
+		var page []substackPost
+		if err := substackGet(fmt.Sprintf("%s/api/v1/archive?sort=new&offset=%d&limit=%d", base, offset, substackPageSize), &page); err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		offset += len(page)
+		older := false
+		for _, post := range page {
+			switch {
+			case !archive.Since.IsZero() && post.PostDate.Before(archive.Since):
+				older = true
+			case !archive.Until.IsZero() && !post.PostDate.Before(archive.Until):
+			// Discussion threads have no body
+			case post.Type == "thread" || len(post.Slug) == 0 || seen[post.Slug]:
+			default:
+				seen[post.Slug] = true
+				posts = append(posts, post)
+			}
+		}
+		if older {
+			break
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].PostDate.Before(posts[j].PostDate)
+	})
+	return posts, nil
+}
+
+// substackPublication returns the name of the publication from its home page
+func substackPublication(base string) string {
+	host := strings.TrimPrefix(hostOf(base), "www.")
+	data, status, err := fetchPage(base)
+	if err != nil || status != http.StatusOK {
+		return host
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return host
+	}
+	if name := strings.TrimSpace(doc.Find(`meta[property="og:site_name"]`).AttrOr("content", "")); len(name) > 0 {
+		return name
+	}
+	return host
+}
+
+// fetchSubstackPost gets a post with its full body from the posts api, paid
+// posts need the cookies of a subscriber
+func fetchSubstackPost(base string, slug string) (Article, error) {
+	var post substackPost
+	if err := substackGet(base+"/api/v1/posts/"+url.PathEscape(slug), &post); err != nil {
+		return Article{}, err
+	}
+	if len(post.CanonicalURL) == 0 {
+		post.CanonicalURL = base + "/p/" + slug
+	}
+	return substackArticle(post)
+}
+
+// fetchSubstack extracts posts of substack.com publications from the api,
+// other pages of the site are left to readability
+func fetchSubstack(pageURL *url.URL) (Article, error) {
+	match := substackPostPath.FindStringSubmatch(pageURL.Path)
+	if match == nil {
+		return Article{}, ErrSkipAdapter
+	}
+	return fetchSubstackPost(pageURL.Scheme+"://"+pageURL.Host, match[1])
+}
+
+// substackArticle cleans up the body of a post, posts without one are only
+// a preview for paid subscribers
+func substackArticle(post substackPost) (Article, error) {
+	body := post.BodyHTML
+	if len(strings.TrimSpace(body)) == 0 {
+		util.Magenta.Printf("Only the preview of %s is readable, paid posts need the cookies of a subscriber\n", post.Title)
+		body = "<p>" + htmlutil.EscapeString(post.TruncatedBody) + "</p><p><em>The rest of this post is for paid subscribers.</em></p>"
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + body + "</body>"))
+	if err != nil {
+		return Article{}, err
+	}
+	doc.Find(substackJunk).Remove()
+	content, err := doc.Find("body").Html()
+	if err != nil {
+		return Article{}, err
+	}
+
+	var authors []string
+	for _, byline := range post.Bylines {
+		authors = append(authors, byline.Name)
+	}
+	article := NewArticle(post.Title, strings.Join(authors, ", "), content, post.CanonicalURL)
+	article.Excerpt = post.Subtitle
+	article.Published = post.PostDate
+	article.Image = post.CoverImage
+	return article, nil
+}
+
+// MakeFromSubstack builds books of the posts in a publication's archive in
+// the order they were published, one for the whole archive or one per year.
+// Returns the paths of the books.
+func MakeFromSubstack(pubURL string, archive SubstackArchive, outputDir string, opts Options) ([]string, error) {
+	base, err := substackBase(pubURL)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := substackArchivePosts(base, archive)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("no posts in the archive of %s", base)
+	}
+	name := substackPublication(base)
+	util.CyanBold.Printf("Found %d posts in the archive of %s\n", len(posts), name)
+
+	// Posts are sorted so the posts of a year follow each other
+	groups := [][]substackPost{posts}
+	if archive.PerYear {
+		groups = nil
+		for i, post := range posts {
+			if i == 0 || post.PostDate.Year() != posts[i-1].PostDate.Year() {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], post)
+		}
+	}
+
+	var paths []string
+	for _, group := range groups {
+		title := name
+		if archive.PerYear {
+			title = fmt.Sprintf("%s %d", name, group[0].PostDate.Year())
+		}
+		postURLs := make([]string, len(group))
+		slugs := make(map[string]string)
+		for i, post := range group {
+			postURLs[i] = base + "/p/" + post.Slug
+			slugs[postURLs[i]] = post.Slug
+		}
+		articles := fetchAllWith(postURLs, func(postURL string) (Article, error) {
+			return fetchSubstackPost(base, slugs[postURL])
+		})
+		if len(articles) == 0 {
+			util.Red.Printf("SKIPPING %s, none of its posts could be fetched\n", title)
+			continue
+		}
+		for i := range articles {
+			articles[i].SiteName = name
+		}
+		path, err := makeBook(articles, title, outputDir, opts)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no posts of %s could be fetched", base)
+	}
+	return paths, nil
+}
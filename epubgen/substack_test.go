@@ -0,0 +1,87 @@
+package epubgen
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func substackServer(t *testing.T) *httptest.Server {
+	archive := []string{
+		`[{"slug":"third","type":"newsletter","post_date":"2024-02-01T09:00:00.000Z"},{"slug":"chat","type":"thread","post_date":"2024-01-20T09:00:00.000Z"}]`,
+		`[{"slug":"second","type":"newsletter","post_date":"2023-11-05T09:00:00.000Z"},{"slug":"first","type":"podcast","post_date":"2023-03-01T09:00:00.000Z"}]`,
+		`[]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			w.Write([]byte(`<html><head><meta property="og:site_name" content="Engine Notes"/></head></html>`))
+		case r.URL.Path == "/api/v1/archive":
+			page := map[string]int{"0": 0, "2": 1, "4": 2}[r.URL.Query().Get("offset")]
+			w.Write([]byte(archive[page]))
+		case strings.HasPrefix(r.URL.Path, "/api/v1/posts/"):
+			slug := strings.TrimPrefix(r.URL.Path, "/api/v1/posts/")
+			body := fmt.Sprintf(`<p>Body of %s</p><div class="subscription-widget-wrap"><p>Subscribe now</p></div>`, slug)
+			if slug == "second" {
+				body = ""
+			}
+			fmt.Fprintf(w, `{"slug":%q,"title":"Post %s","subtitle":"About %s","post_date":"2023-01-01T00:00:00Z","body_html":%q,
+"truncated_body_text":"Preview of %s","publishedBylines":[{"name":"Ada"}]}`, slug, slug, slug, body, slug)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSubstackArchivePosts(t *testing.T) {
+	server := substackServer(t)
+	posts, err := substackArchivePosts(server.URL, SubstackArchive{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slugs []string
+	for _, post := range posts {
+		slugs = append(slugs, post.Slug)
+	}
+	if strings.Join(slugs, ",") != "first,second,third" {
+		t.Errorf("expected the posts oldest first without threads, got %v", slugs)
+	}
+
+	posts, err = substackArchivePosts(server.URL, SubstackArchive{
+		Since: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Slug != "second" {
+		t.Errorf("expected only the second post in range, got %+v", posts)
+	}
+}
+
+func TestMakeFromSubstack(t *testing.T) {
+	server := substackServer(t)
+	dir := t.TempDir()
+	paths, err := MakeFromSubstack(server.URL+"/archive", SubstackArchive{PerYear: true}, dir, Options{Format: FormatHTML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || !strings.HasSuffix(paths[0], "engine-notes-2023.html") || !strings.HasSuffix(paths[1], "engine-notes-2024.html") {
+		t.Fatalf("unexpected books %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := string(data)
+	first, second := strings.Index(book, "Body of first"), strings.Index(book, "Preview of second")
+	if first < 0 || second < first || strings.Contains(book, "Subscribe now") || !strings.Contains(book, "for paid subscribers") {
+		t.Errorf("unexpected book %s", book)
+	}
+}
@@ -0,0 +1,162 @@
+package epubgen
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// chapter is the part of an article starting at one of its headings
+type chapter struct {
+	Title string
+	Body  string
+}
+
+// headingLevel returns 2 for h2, 3 for h3 ... and 0 for anything else
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode {
+		return 0
+	}
+	switch n.DataAtom {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// isSplitHeading reports if n is a heading the article is split at, depth 1
+// splits at h2, depth 2 at h2 and h3 and so on
+func isSplitHeading(n *html.Node, depth int) bool {
+	level := headingLevel(n)
+	return level >= 2 && level <= depth+1
+}
+
+// headingContainer finds the element holding the headings of the article,
+// readability wraps the content in one or more divs
+func headingContainer(n *html.Node, depth int) *html.Node {
+	for {
+		var only *html.Node
+		elements := 0
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if isSplitHeading(c, depth) {
+				return n
+			}
+			if c.Type == html.ElementNode {
+				only = c
+				elements++
+			} else if c.Type == html.TextNode && len(strings.TrimSpace(c.Data)) > 0 {
+				return n
+			}
+		}
+		if elements != 1 {
+			return n
+		}
+		n = only
+	}
+}
+
+// tocDepth is the depth the article is split at, the one of the options
+// unless the article needs a deeper one
+func tocDepth(article *Article, opts Options) int {
+	return max(opts.TOCDepth, article.MinTOCDepth)
+}
+
+// splitAtHeadings splits the content of an article at its top level headings
+// down to the given depth. It returns the content before the first heading
+// and a chapter for every heading, or no chapters if there is nothing to split.
+func splitAtHeadings(content string, depth int) (string, []chapter) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil || depth < 1 {
+		return content, nil
+	}
+	body := doc.Find("body").Get(0)
+	if body == nil {
+		return content, nil
+	}
+	container := headingContainer(body, depth)
+
+	var intro strings.Builder
+	var titles []string
+	var bodies []*strings.Builder
+	current := &intro
+	for c := container.FirstChild; c != nil; c = c.NextSibling {
+		if isSplitHeading(c, depth) {
+			titles = append(titles, strings.TrimSpace(goquery.NewDocumentFromNode(c).Text()))
+			current = &strings.Builder{}
+			bodies = append(bodies, current)
+		}
+		if err := html.Render(current, c); err != nil {
+			return content, nil
+		}
+	}
+	if len(bodies) == 0 {
+		return content, nil
+	}
+
+	chapters := make([]chapter, len(bodies))
+	for i := range bodies {
+		chapters[i] = chapter{Title: titles[i], Body: bodies[i].String()}
+	}
+	return intro.String(), chapters
+}
+
+// linkSections points the links to anchors of an article, like references
+// to its footnotes, at the section file the anchor ended up in once the
+// article is split. parts are the bodies of the sections, files their names.
+func linkSections(parts []string, files []string) []string {
+	fileOf := make(map[string]string)
+	docs := make([]*goquery.Document, len(parts))
+	for i, part := range parts {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(part))
+		if err != nil {
+			continue
+		}
+		docs[i] = doc
+		doc.Find("[id], a[name]").Each(func(_ int, s *goquery.Selection) {
+			id, ok := s.Attr("id")
+			if !ok {
+				id = s.AttrOr("name", "")
+			}
+			if _, seen := fileOf[id]; !seen {
+				fileOf[id] = files[i]
+			}
+		})
+	}
+	for i, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		changed := false
+		doc.Find(`a[href^="#"]`).Each(func(_ int, a *goquery.Selection) {
+			href := a.AttrOr("href", "")
+			id := href[1:]
+			if unescaped, err := url.PathUnescape(id); err == nil {
+				id = unescaped
+			}
+			if file, ok := fileOf[id]; ok && file != files[i] {
+				a.SetAttr("href", file+href)
+				changed = true
+			}
+		})
+		if !changed {
+			continue
+		}
+		if body, err := doc.Find("body").Html(); err == nil {
+			parts[i] = body
+		}
+	}
+	return parts
+}
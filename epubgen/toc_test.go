@@ -0,0 +1,54 @@
+package epubgen
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLinkSections(t *testing.T) {
+	parts := linkSections([]string{
+		`<p>Claim<sup id="cite_ref-1"><a href="#cite_note-1">[1]</a></sup> <a href="#top">top</a></p><a name="top"></a>`,
+		`<ol><li id="cite_note-1"><a href="#cite_ref-1">^</a> A source</li></ol>`,
+	}, []string{"article001.xhtml", "article001-01.xhtml"})
+	if !strings.Contains(parts[0], `href="article001-01.xhtml#cite_note-1"`) || !strings.Contains(parts[0], `href="#top"`) {
+		t.Errorf("unexpected intro %s", parts[0])
+	}
+	if !strings.Contains(parts[1], `href="article001.xhtml#cite_ref-1"`) {
+		t.Errorf("unexpected references %s", parts[1])
+	}
+}
+
+// bookNav returns the navigation document of an epub
+func bookNav(t *testing.T, path string) string {
+	t.Helper()
+	return bookFile(t, path, "nav.xhtml")
+}
+
+// bookFile returns the first file of an epub whose name ends with suffix
+func bookFile(t *testing.T, path string, suffix string) string {
+	t.Helper()
+	book, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	for _, file := range book.File {
+		if !strings.HasSuffix(file.Name, suffix) {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	t.Fatalf("no %s in %s", suffix, path)
+	return ""
+}
@@ -0,0 +1,245 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// cue is a caption of a transcript
+type cue struct {
+	Start   time.Duration
+	Speaker string
+	Text    string
+}
+
+var (
+	cueTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->`)
+	cueVoice  = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]+)>`)
+	cueTag    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// isTranscriptFile reports whether name is a WebVTT or SubRip file
+func isTranscriptFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".vtt" || ext == ".srt"
+}
+
+// cueTime parses a timestamp like 01:02:03.450 or 02:03,450
+func cueTime(stamp string) time.Duration {
+	parts := strings.Split(strings.Replace(stamp, ",", ".", 1), ":")
+	var total time.Duration
+	for _, part := range parts[:len(parts)-1] {
+		n, _ := strconv.Atoi(part)
+		total = total*60 + time.Duration(n)
+	}
+	seconds, _ := strconv.ParseFloat(parts[len(parts)-1], 64)
+	return total*60*time.Second + time.Duration(seconds*float64(time.Second))
+}
+
+// parseCaptions reads the cues of a WebVTT or SubRip file, voice tags of
+// WebVTT give the speaker
+func parseCaptions(data string) []cue {
+	var cues []cue
+	var current *cue
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if match := cueTiming.FindStringSubmatch(line); match != nil {
+			cues = append(cues, cue{Start: cueTime(match[1])})
+			current = &cues[len(cues)-1]
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if current == nil || len(line) == 0 {
+			// Numbers of SubRip cues and WebVTT notes come outside of cues
+			current = nil
+			continue
+		}
+		if voice := cueVoice.FindStringSubmatch(line); voice != nil {
+			current.Speaker = strings.TrimSpace(voice[1])
+		}
+		text := strings.TrimSpace(htmlutil.UnescapeString(cueTag.ReplaceAllString(line, "")))
+		if len(current.Text) > 0 {
+			current.Text += "\n"
+		}
+		current.Text += text
+	}
+	return cues
+}
+
+// transcriptParagraph is a run of cues read as one paragraph
+type transcriptParagraph struct {
+	Start   time.Duration
+	Speaker string
+	Text    string
+}
+
+// transcriptParagraphs groups cues into paragraphs of about a minute, broken
+// at the end of a sentence, or when the speaker changes. Lines repeated by
+// rolling captions are only kept once.
+func transcriptParagraphs(cues []cue) []transcriptParagraph {
+	var paragraphs []transcriptParagraph
+	var text []string
+	var last string
+	for _, c := range cues {
+		// A cue only repeating the last line may have closed the paragraph already
+		if len(paragraphs) > 0 && len(text) > 0 {
+			p := &paragraphs[len(paragraphs)-1]
+			elapsed := c.Start - p.Start
+			sentenceEnd := strings.ContainsAny(text[len(text)-1][len(text[len(text)-1])-1:], ".?!")
+			if (len(c.Speaker) > 0 && c.Speaker != p.Speaker) || (elapsed >= 45*time.Second && sentenceEnd) || elapsed >= 90*time.Second {
+				p.Text = strings.Join(text, " ")
+				text = nil
+			}
+		}
+		for _, line := range strings.Split(c.Text, "\n") {
+			if len(line) == 0 || line == last {
+				continue
+			}
+			if len(text) == 0 {
+				speaker := c.Speaker
+				if len(speaker) == 0 && len(paragraphs) > 0 {
+					speaker = paragraphs[len(paragraphs)-1].Speaker
+				}
+				paragraphs = append(paragraphs, transcriptParagraph{Start: c.Start, Speaker: speaker})
+			}
+			text = append(text, line)
+			last = line
+		}
+	}
+	if len(paragraphs) > 0 && len(text) > 0 {
+		paragraphs[len(paragraphs)-1].Text = strings.Join(text, " ")
+	}
+	return paragraphs
+}
+
+// formatTimestamp writes a position in a recording like 1:02:03 or 2:03
+func formatTimestamp(d time.Duration) string {
+	seconds := int(d.Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// renderTranscript writes the paragraphs of a transcript, each starting with
+// its timestamp, which links to that moment when link is given
+func renderTranscript(paragraphs []transcriptParagraph, link func(time.Duration) string) string {
+	var out strings.Builder
+	speaker := ""
+	for _, p := range paragraphs {
+		stamp := "[" + formatTimestamp(p.Start) + "]"
+		if link != nil {
+			stamp = `<a href="` + htmlutil.EscapeString(link(p.Start)) + `">` + stamp + "</a>"
+		}
+		out.WriteString(`<p class="transcript"><span class="timestamp">` + stamp + "</span> ")
+		if p.Speaker != speaker && len(p.Speaker) > 0 {
+			out.WriteString("<strong>" + htmlutil.EscapeString(p.Speaker) + ":</strong> ")
+		}
+		speaker = p.Speaker
+		out.WriteString(htmlutil.EscapeString(p.Text) + "</p>")
+	}
+	return out.String()
+}
+
+// transcriptTitle makes a title from the name of a transcript file
+func transcriptTitle(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return strings.TrimSpace(strings.NewReplacer("-", " ", "_", " ", ".", " ").Replace(name))
+}
+
+// fetchTranscript makes an article of a WebVTT or SubRip file
+func fetchTranscript(pageURL string, parsedURL *url.URL) (Article, error) {
+	data, status, err := fetchPage(pageURL)
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New(http.StatusText(status))
+	}
+	cues := parseCaptions(string(data))
+	if len(cues) == 0 {
+		return Article{}, errors.New("no captions in the transcript")
+	}
+	return NewArticle(transcriptTitle(path.Base(parsedURL.Path)), "", renderTranscript(transcriptParagraphs(cues), nil), pageURL), nil
+}
+
+// Where pages like podcast episodes link their transcript. Other links are
+// only taken when they say they are the transcript, pages listing caption
+// files of other videos aren't transcripts of their own.
+const transcriptLinks = `track[kind="captions"][src], track[kind="subtitles"][src], link[rel~="alternate"][type="text/vtt"][href], a[href]`
+
+// isTranscriptLink reports whether an element found with transcriptLinks
+// points at the transcript of the page
+func isTranscriptLink(s *goquery.Selection) bool {
+	if goquery.NodeName(s) != "a" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(s.Text()+" "+s.AttrOr("rel", "")), "transcript")
+}
+
+// addTranscript appends the transcript a page links to, like a podcast
+// episode with a WebVTT or SubRip file, to its article
+func addTranscript(article *Article, page []byte, pageURL *url.URL) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return
+	}
+	var link string
+	doc.Find(transcriptLinks).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if !isTranscriptLink(s) {
+			return true
+		}
+		ref := s.AttrOr("src", s.AttrOr("href", ""))
+		target, err := pageURL.Parse(strings.TrimSpace(ref))
+		if err != nil || !isTranscriptFile(target.Path) {
+			return true
+		}
+		link = target.String()
+		return false
+	})
+	if len(link) == 0 {
+		return
+	}
+	data, status, err := fetchPage(link)
+	if err == nil && status != http.StatusOK {
+		err = errors.New(http.StatusText(status))
+	}
+	if err != nil {
+		util.Red.Printf("Couldn't fetch the transcript of %s : %s\n", article.Title, err)
+		return
+	}
+	cues := parseCaptions(string(data))
+	if len(cues) == 0 {
+		return
+	}
+	util.Cyan.Printf("Added the transcript of %s\n", article.Title)
+	appendPage(article, NewArticle("", "", "<h2>Transcript</h2>"+renderTranscript(transcriptParagraphs(cues), nil), "").Article)
+}
+
+// MakeFromTranscript builds a book from a local WebVTT or SubRip file
+func MakeFromTranscript(file string, outputDir string, opts Options) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	cues := parseCaptions(string(data))
+	if len(cues) == 0 {
+		return "", fmt.Errorf("no captions in %s", file)
+	}
+	title := transcriptTitle(filepath.Base(file))
+	article := NewArticle(title, "", renderTranscript(transcriptParagraphs(cues), nil), "")
+	return makeBook([]Article{article}, title, outputDir, opts)
+}
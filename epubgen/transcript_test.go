@@ -0,0 +1,140 @@
+package epubgen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const vttTranscript = `WEBVTT
+
+NOTE recorded live
+
+1
+00:00:01.000 --> 00:00:04.000
+<v Ada>Welcome to the show.
+
+00:00:05.500 --> 00:00:09.000
+<v Ada>Today we talk about engines.
+
+00:00:50.000 --> 00:00:53.000
+<v Charles>Thanks &amp; hello.
+
+00:01:00.000 --> 00:01:03.000
+<v Charles>It is a pleasure.
+`
+
+const srtTranscript = "1\r\n00:00:00,500 --> 00:00:02,000\r\nhello there\r\n\r\n2\r\n00:00:02,000 --> 00:00:04,000\r\nhello there\r\nhow are <i>you</i>\r\n\r\n3\r\n01:00:01,000 --> 01:00:03,000\r\nlater on\r\n"
+
+func TestParseCaptions(t *testing.T) {
+	cues := parseCaptions(vttTranscript)
+	if len(cues) != 4 {
+		t.Fatalf("expected 4 cues, got %+v", cues)
+	}
+	if cues[1].Start != 5500*time.Millisecond || cues[1].Speaker != "Ada" || cues[1].Text != "Today we talk about engines." {
+		t.Errorf("unexpected cue %+v", cues[1])
+	}
+	if cues[2].Text != "Thanks & hello." {
+		t.Errorf("entities not decoded: %q", cues[2].Text)
+	}
+
+	cues = parseCaptions(srtTranscript)
+	if len(cues) != 3 || cues[1].Text != "hello there\nhow are you" || cues[2].Start != time.Hour+time.Second {
+		t.Errorf("unexpected srt cues %+v", cues)
+	}
+}
+
+func TestTranscriptParagraphs(t *testing.T) {
+	paragraphs := transcriptParagraphs(parseCaptions(vttTranscript))
+	if len(paragraphs) != 2 || paragraphs[0].Speaker != "Ada" || paragraphs[1].Start != 50*time.Second ||
+		paragraphs[1].Text != "Thanks & hello. It is a pleasure." {
+		t.Fatalf("unexpected paragraphs %+v", paragraphs)
+	}
+
+	// Rolling captions repeat their last line
+	paragraphs = transcriptParagraphs(parseCaptions(srtTranscript))
+	if len(paragraphs) != 2 || paragraphs[0].Text != "hello there how are you" {
+		t.Errorf("unexpected paragraphs %+v", paragraphs)
+	}
+
+	// A repeated line closing a paragraph leaves its text alone
+	paragraphs = transcriptParagraphs([]cue{
+		{Start: 0, Text: "first line"},
+		{Start: 95 * time.Second, Text: "first line"},
+		{Start: 100 * time.Second, Text: "second line"},
+	})
+	if len(paragraphs) != 2 || paragraphs[0].Text != "first line" || paragraphs[1].Start != 100*time.Second || paragraphs[1].Text != "second line" {
+		t.Errorf("unexpected paragraphs %+v", paragraphs)
+	}
+	paragraphs = transcriptParagraphs([]cue{{Start: 0, Text: "only line"}, {Start: 95 * time.Second, Text: "only line"}})
+	if len(paragraphs) != 1 || paragraphs[0].Text != "only line" {
+		t.Errorf("unexpected paragraphs %+v", paragraphs)
+	}
+
+	paragraphs = transcriptParagraphs(parseCaptions(srtTranscript))
+	content := renderTranscript(paragraphs, func(at time.Duration) string { return "https://example.com/?t=" + at.String() })
+	for _, want := range []string{`<a href="https://example.com/?t=500ms">[0:00]</a>`, `[1:00:01]`} {
+		if !strings.Contains(content, want) {
+			t.Errorf("expected %q in %s", want, content)
+		}
+	}
+}
+
+func TestAddTranscript(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/episode.vtt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(vttTranscript))
+	}))
+	defer server.Close()
+	pageURL, _ := url.Parse(server.URL + "/episode")
+	for page, want := range map[string]bool{
+		`<audio src="/episode.mp3"><track kind="captions" src="/episode.vtt"/></audio>`: true,
+		`<link rel="alternate" type="text/vtt" href="/episode.vtt">`:                    true,
+		`<p><a href="/episode.vtt">Read the transcript</a></p>`:                         true,
+		`<p><a rel="transcript" href="/episode.vtt">Text version</a></p>`:               true,
+		`<ul><li><a href="/episode.vtt">English subtitles</a></li><li>French</li></ul>`: false,
+		`<track kind="chapters" src="/episode.vtt"/>`:                                   false,
+	} {
+		page = `<html><head></head><body><article><h1>Episode 1</h1>` + page + `</article></body></html>`
+		article := NewArticle("Episode 1", "", "<p>Show notes</p>", pageURL.String())
+		addTranscript(&article, []byte(page), pageURL)
+		if got := strings.Contains(article.Content, "<h2>Transcript</h2>"); got != want {
+			t.Errorf("transcript added to %s = %v, want %v", page, got, want)
+		}
+		if !want {
+			continue
+		}
+		for _, want := range []string{"<p>Show notes</p>", "<strong>Charles:</strong> Thanks &amp; hello."} {
+			if !strings.Contains(article.Content, want) {
+				t.Errorf("expected %q in %s", want, article.Content)
+			}
+		}
+	}
+}
+
+func TestMakeFromTranscript(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "team-meeting.srt")
+	if err := os.WriteFile(file, []byte(srtTranscript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := MakeFromTranscript(file, dir, Options{Format: FormatHTML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "team meeting") || !strings.Contains(string(data), "hello there how are you") {
+		t.Errorf("unexpected book %s", data)
+	}
+}
@@ -0,0 +1,103 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func init() {
+	RegisterAdapter("twitter.com", AdapterFunc(fetchTweet))
+	RegisterAdapter("x.com", AdapterFunc(fetchTweet))
+}
+
+// Tweets are rendered by scripts, the oEmbed endpoint returns their text
+// without logging in
+var twitterOEmbed = "https://publish.twitter.com/oembed"
+
+var tweetPath = regexp.MustCompile(`^/([^/]+)/status(?:es)?/(\d+)`)
+
+// tweetEmbed is the oEmbed response for a tweet
+type tweetEmbed struct {
+	AuthorName string `json:"author_name"`
+	AuthorURL  string `json:"author_url"`
+	HTML       string `json:"html"`
+}
+
+// fetchTweet turns a tweet into an article, other pages of the site are
+// left to readability
+func fetchTweet(pageURL *url.URL) (Article, error) {
+	match := tweetPath.FindStringSubmatch(pageURL.Path)
+	if match == nil {
+		return Article{}, ErrSkipAdapter
+	}
+	tweetURL := "https://twitter.com/" + match[1] + "/status/" + match[2]
+	query := url.Values{"url": {tweetURL}, "omit_script": {"true"}, "dnt": {"true"}}
+	data, status, err := fetchPage(twitterOEmbed + "?" + query.Encode())
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("oembed: " + http.StatusText(status))
+	}
+	var embed tweetEmbed
+	if err := json.Unmarshal(data, &embed); err != nil {
+		return Article{}, err
+	}
+	return tweetArticle(embed, pageURL.String())
+}
+
+// tweetArticle builds the article of a tweet from its embed, a blockquote
+// with the text followed by the author and a link dated with the tweet
+func tweetArticle(embed tweetEmbed, source string) (Article, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(embed.HTML))
+	if err != nil {
+		return Article{}, err
+	}
+	var content strings.Builder
+	var text []string
+	doc.Find("blockquote > p").Each(func(_ int, p *goquery.Selection) {
+		if inner, err := p.Html(); err == nil {
+			content.WriteString("<p>" + inner + "</p>")
+			text = append(text, strings.TrimSpace(p.Text()))
+		}
+	})
+	if content.Len() == 0 {
+		return Article{}, errors.New("no tweet text in the embed")
+	}
+
+	author := embed.AuthorName
+	if handle := strings.TrimPrefix(strings.TrimPrefix(embed.AuthorURL, "https://twitter.com/"), "https://x.com/"); len(handle) > 0 && handle != embed.AuthorURL {
+		author = fmt.Sprintf("%s (@%s)", embed.AuthorName, handle)
+	}
+	content.WriteString(`<p class="tweet-author">— ` + htmlutil.EscapeString(author) + "</p>")
+
+	title := embed.AuthorName + " on X"
+	if summary := collapseSpace(strings.Join(text, " ")); len(summary) > 0 {
+		title += ": " + truncateWords(summary, 60)
+	}
+	article := NewArticle(title, embed.AuthorName, content.String(), source)
+	article.Published, _ = time.Parse("January 2, 2006", strings.TrimSpace(doc.Find("blockquote > a").Last().Text()))
+	return article, nil
+}
+
+// truncateWords shortens text to at most limit characters, cutting between words
+func truncateWords(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	cut := string(runes[:limit])
+	if space := strings.LastIndex(cut, " "); space > limit/2 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
@@ -0,0 +1,180 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func init() {
+	RegisterAdapter("wikipedia.org", AdapterFunc(fetchWikipedia))
+}
+
+// The parse API returns the rendered article without the skin around it and
+// leaves out the edit links
+var wikipediaAPI = "https://%s.wikipedia.org/w/api.php"
+
+// Chrome of the page and boxes that don't read well outside of the site
+var wikipediaJunk = strings.Join([]string{
+	"style", "link", "script", "noscript",
+	".mw-editsection", ".mw-jump-link", ".mw-empty-elt", ".shortdescription", ".hatnote",
+	".navbox", ".navbox-styles", ".vertical-navbox", ".sidebar", ".authority-control", ".portalbox",
+	".ambox", ".metadata", ".noprint", ".sistersitebox", ".toc", "#toc",
+	"#coordinates", ".geo-default", ".geo-nondefault", ".catlinks",
+	`[style*="display:none"]`, `[style*="display: none"]`,
+}, ", ")
+
+// wikipediaParse is the response of the parse API
+type wikipediaParse struct {
+	Parse struct {
+		Title string `json:"title"`
+		Text  string `json:"text"`
+	} `json:"parse"`
+	Error *struct {
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// fetchWikipedia extracts an article of any language edition, other pages
+// of the site are left to readability
+func fetchWikipedia(pageURL *url.URL) (Article, error) {
+	title, ok := strings.CutPrefix(pageURL.Path, "/wiki/")
+	if !ok && pageURL.Path == "/w/index.php" {
+		title = pageURL.Query().Get("title")
+	}
+	lang := strings.Split(strings.ToLower(pageURL.Hostname()), ".")[0]
+	if len(title) == 0 || lang == "www" || lang == "m" || lang == "wikipedia" {
+		return Article{}, ErrSkipAdapter
+	}
+
+	query := url.Values{
+		"action": {"parse"}, "format": {"json"}, "formatversion": {"2"}, "redirects": {"1"},
+		"prop": {"text"}, "disableeditsection": {"1"}, "disabletoc": {"1"}, "page": {title},
+	}
+	data, status, err := fetchPage(fmt.Sprintf(wikipediaAPI, lang) + "?" + query.Encode())
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("wikipedia api: " + http.StatusText(status))
+	}
+	var parsed wikipediaParse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return Article{}, err
+	}
+	if parsed.Error != nil {
+		return Article{}, errors.New("wikipedia api: " + parsed.Error.Info)
+	}
+	base := &url.URL{Scheme: "https", Host: pageURL.Host, Path: "/wiki/" + parsed.Parse.Title}
+	return wikipediaArticle(parsed.Parse.Title, parsed.Parse.Text, lang, base, pageURL.String())
+}
+
+// wikipediaArticle cleans up the rendered html of an article. Citations keep
+// linking to the references list, which works as the endnotes of the article
+// and becomes the footnotes of FB2 books. Sections are always split out.
+func wikipediaArticle(title string, text string, lang string, base *url.URL, source string) (Article, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		return Article{}, err
+	}
+	output := doc.Find(".mw-parser-output").First()
+	if output.Length() == 0 {
+		output = doc.Find("body")
+	}
+	// Editions like simple.wikipedia.org are written in another language than their name
+	if code, ok := output.Attr("lang"); ok && len(code) > 0 {
+		lang = code
+	}
+
+	output.Find(wikipediaJunk).Remove()
+	// Headings are wrapped with their edit links, unwrap them so articles split at them
+	output.Find(".mw-heading").Each(func(_ int, s *goquery.Selection) {
+		s.ReplaceWithSelection(s.Contents())
+	})
+	output.Find("table.infobox").Each(func(_ int, table *goquery.Selection) {
+		table.ReplaceWithHtml(simpleInfobox(table))
+	})
+	resolveWikipediaLinks(output, base)
+
+	content, err := output.Html()
+	if err != nil {
+		return Article{}, err
+	}
+	article := NewArticle(title, "Wikipedia contributors", content, source)
+	article.SiteName = "Wikipedia"
+	article.Language = lang
+	article.MinTOCDepth = 1
+	output.Children().FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return goquery.NodeName(s) == "p" && len(strings.TrimSpace(s.Text())) > 0
+	}).First().Each(func(_ int, p *goquery.Selection) {
+		article.Excerpt = truncateWords(collapseSpace(p.Text()), 300)
+	})
+	return article, nil
+}
+
+// simpleInfobox rewrites an infobox as a plain two column table, without the
+// layout and colours that only work on the site
+func simpleInfobox(table *goquery.Selection) string {
+	var out strings.Builder
+	out.WriteString(`<table class="infobox">`)
+	table.Find("tr").Each(func(_ int, tr *goquery.Selection) {
+		// Rows of nested tables are written with their outer row
+		if tr.ParentsFiltered("table").First().Get(0) != table.Get(0) {
+			return
+		}
+		cells := tr.ChildrenFiltered("th, td")
+		if len(strings.TrimSpace(cells.Text())) == 0 && cells.Find("img").Length() == 0 {
+			return
+		}
+		cells.Find("*").RemoveAttr("style").RemoveAttr("class")
+		if cells.Length() == 1 {
+			inner, _ := cells.Html()
+			if goquery.NodeName(cells) == "th" {
+				out.WriteString(`<tr><th colspan="2">` + inner + "</th></tr>")
+			} else {
+				out.WriteString(`<tr><td colspan="2">` + inner + "</td></tr>")
+			}
+			return
+		}
+		label, _ := cells.First().Html()
+		var values []string
+		cells.Slice(1, cells.Length()).Each(func(_ int, td *goquery.Selection) {
+			value, _ := td.Html()
+			values = append(values, value)
+		})
+		out.WriteString("<tr><th>" + label + "</th><td>" + strings.Join(values, " ") + "</td></tr>")
+	})
+	out.WriteString("</table>")
+	return out.String()
+}
+
+// resolveWikipediaLinks makes the links and images of an article absolute,
+// the parse API leaves them relative to the site
+func resolveWikipediaLinks(output *goquery.Selection, base *url.URL) {
+	output.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href := a.AttrOr("href", "")
+		if strings.HasPrefix(href, "#") {
+			return
+		}
+		if link, err := base.Parse(href); err == nil {
+			a.SetAttr("href", link.String())
+		}
+	})
+	output.Find("img").Each(func(_ int, img *goquery.Selection) {
+		src := img.AttrOr("src", "")
+		// Thumbnails are small, the last source of the srcset is the sharpest
+		candidates := strings.Split(img.AttrOr("srcset", ""), ",")
+		if last := strings.Fields(candidates[len(candidates)-1]); len(last) > 0 {
+			src = last[0]
+		}
+		if link, err := base.Parse(src); err == nil {
+			img.SetAttr("src", link.String())
+		}
+		img.RemoveAttr("srcset")
+	})
+}
@@ -0,0 +1,97 @@
+package epubgen
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+const wikipediaText = `<div class="mw-content-ltr mw-parser-output" lang="en" dir="ltr">
+<div class="shortdescription nomobile noexcerpt noprint searchaux" style="display:none">Programming language</div>
+<div role="note" class="hatnote navigation-not-searchable">For the game, see Go (game).</div>
+<table class="infobox vevent"><tbody>
+<tr><th colspan="2" class="infobox-above" style="background:#eee">Go</th></tr>
+<tr><td colspan="2" class="infobox-image"><span><img src="//upload.wikimedia.org/logo.png" srcset="//upload.wikimedia.org/logo-1.5x.png 1.5x, //upload.wikimedia.org/logo-2x.png 2x"/></span></td></tr>
+<tr><th scope="row" class="infobox-label">Designed&nbsp;by</th><td class="infobox-data">Robert Griesemer</td></tr>
+</tbody></table>
+<p class="mw-empty-elt"></p>
+<p><b>Go</b> is a <a href="/wiki/Programming_language" title="Programming language">programming language</a>.<sup id="cite_ref-1" class="reference"><a href="#cite_note-1"><span class="cite-bracket">[</span>1<span class="cite-bracket">]</span></a></sup></p>
+<div class="mw-heading mw-heading2"><h2 id="History">History</h2><span class="mw-editsection">[edit]</span></div>
+<p>It was designed at Google.</p>
+<div class="mw-heading mw-heading2"><h2 id="References">References</h2></div>
+<div class="reflist"><ol class="references"><li id="cite_note-1"><span class="mw-cite-backlink"><b><a href="#cite_ref-1">^</a></b></span> <span class="reference-text">A source.</span></li></ol></div>
+<div class="navbox" role="navigation">Programming languages</div>
+</div>`
+
+func TestWikipediaArticle(t *testing.T) {
+	base, _ := url.Parse("https://simple.wikipedia.org/wiki/Go_(programming_language)")
+	article, err := wikipediaArticle("Go (programming language)", wikipediaText, "simple", base, base.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Language != "en" || article.Excerpt != "Go is a programming language.[1]" {
+		t.Errorf("unexpected metadata %q %q", article.Language, article.Excerpt)
+	}
+	for _, want := range []string{
+		`<a href="https://simple.wikipedia.org/wiki/Programming_language"`,
+		`<a href="#cite_note-1">`,
+		`<li id="cite_note-1">`,
+		"<th>Designed\u00a0by</th><td>Robert Griesemer</td>",
+		`<img src="https://upload.wikimedia.org/logo-2x.png"/>`,
+		"<h2 id=\"History\">History</h2>\n<p>",
+	} {
+		if !strings.Contains(article.Content, want) {
+			t.Errorf("expected %q in %s", want, article.Content)
+		}
+	}
+	for _, junk := range []string{"Programming language</div>", "Go (game)", "[edit]", "Programming languages", "background", "mw-empty-elt"} {
+		if strings.Contains(article.Content, junk) {
+			t.Errorf("expected %q to be removed from %s", junk, article.Content)
+		}
+	}
+	if _, chapters := splitAtHeadings(article.Content, 1); len(chapters) != 2 {
+		t.Errorf("expected the sections to be split, got %d", len(chapters))
+	}
+}
+
+func TestWikipediaBook(t *testing.T) {
+	base, _ := url.Parse("https://en.wikipedia.org/wiki/Go_(programming_language)")
+	article, err := wikipediaArticle("Go (programming language)", wikipediaText, "en", base, base.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path, err := makeBook([]Article{article}, "Go", dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nav := bookNav(t, path)
+	for _, want := range []string{">History</a>", ">References</a>"} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("expected %s in the table of contents without --toc-depth, got %s", want, nav)
+		}
+	}
+
+	path, err = makeBook([]Article{article}, "Go", dir, Options{Format: FormatFB2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := string(data)
+	for _, want := range []string{
+		`<a l:href="#a1-fn-1" type="note">[1]</a>`,
+		`<body name="notes"><title><p>Notes</p></title><section id="a1-fn-1"><title><p>1</p></title><p>A source.</p></section>`,
+		"<section><title><p>History</p></title>",
+	} {
+		if !strings.Contains(book, want) {
+			t.Errorf("expected %s in %s", want, book)
+		}
+	}
+	if strings.Contains(book, "cite_note") {
+		t.Errorf("expected no links to the references list, got %s", book)
+	}
+}
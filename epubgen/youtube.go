@@ -0,0 +1,223 @@
+package epubgen
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	htmlutil "html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+func init() {
+	RegisterAdapter("youtube.com", AdapterFunc(fetchYouTube))
+	RegisterAdapter("youtu.be", AdapterFunc(fetchYouTube))
+}
+
+var youtubeSite = "https://www.youtube.com"
+
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// youtubePlayer is the part of the player response of a watch page with the
+// details and captions of a video
+type youtubePlayer struct {
+	VideoDetails struct {
+		Title            string `json:"title"`
+		Author           string `json:"author"`
+		ShortDescription string `json:"shortDescription"`
+	} `json:"videoDetails"`
+	Captions struct {
+		Renderer struct {
+			Tracks []youtubeTrack `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+	Microformat struct {
+		Renderer struct {
+			PublishDate string `json:"publishDate"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+// youtubeTrack is a caption track of a video, kind is asr when the captions
+// are generated
+type youtubeTrack struct {
+	BaseURL      string `json:"baseUrl"`
+	LanguageCode string `json:"languageCode"`
+	Kind         string `json:"kind"`
+}
+
+// youtubeVideoID returns the id of the video at pageURL, from watch, short,
+// live and embed pages or short links
+func youtubeVideoID(pageURL *url.URL) (string, bool) {
+	id := ""
+	if hostMatches("youtu.be", strings.ToLower(pageURL.Hostname())) {
+		id = strings.Trim(pageURL.Path, "/")
+	} else if pageURL.Path == "/watch" {
+		id = pageURL.Query().Get("v")
+	} else {
+		for _, prefix := range []string{"/shorts/", "/live/", "/embed/"} {
+			if strings.HasPrefix(pageURL.Path, prefix) {
+				id = strings.Trim(strings.TrimPrefix(pageURL.Path, prefix), "/")
+			}
+		}
+	}
+	return id, youtubeID.MatchString(id)
+}
+
+// fetchYouTube turns a video into the article of its transcript, other pages
+// of the site are left to readability
+func fetchYouTube(pageURL *url.URL) (Article, error) {
+	id, ok := youtubeVideoID(pageURL)
+	if !ok {
+		return Article{}, ErrSkipAdapter
+	}
+	watchURL := youtubeSite + "/watch?v=" + id
+	data, status, err := fetchPage(watchURL)
+	if err != nil {
+		return Article{}, err
+	}
+	if status != http.StatusOK {
+		return Article{}, errors.New("youtube: " + http.StatusText(status))
+	}
+	player, err := youtubePlayerResponse(string(data))
+	if err != nil {
+		return Article{}, err
+	}
+
+	var cues []cue
+	track, ok := youtubeCaptionTrack(player.Captions.Renderer.Tracks)
+	if ok {
+		cues, err = youtubeCaptions(track.BaseURL)
+		if err != nil {
+			util.Red.Printf("Couldn't fetch the captions of %s : %s\n", player.VideoDetails.Title, err)
+		}
+	}
+	article := youtubeArticle(id, player, cues, watchURL)
+	if len(cues) > 0 {
+		article.Language = track.LanguageCode
+	}
+	return article, nil
+}
+
+// youtubePlayerResponse reads the player response embedded in a watch page
+func youtubePlayerResponse(page string) (youtubePlayer, error) {
+	var player youtubePlayer
+	idx := strings.Index(page, "ytInitialPlayerResponse")
+	if idx < 0 {
+		return player, errors.New("no player response on the youtube page")
+	}
+	start := strings.Index(page[idx:], "{")
+	if start < 0 {
+		return player, errors.New("no player response on the youtube page")
+	}
+	// The decoder stops at the end of the object, the script goes on after it
+	if err := json.NewDecoder(strings.NewReader(page[idx+start:])).Decode(&player); err != nil {
+		return player, err
+	}
+	if len(player.VideoDetails.Title) == 0 {
+		return player, errors.New("no video on the youtube page")
+	}
+	return player, nil
+}
+
+// youtubeCaptionTrack picks written captions over generated ones, in English
+// when there is a choice
+func youtubeCaptionTrack(tracks []youtubeTrack) (youtubeTrack, bool) {
+	best, score := youtubeTrack{}, -1
+	for _, track := range tracks {
+		s := 0
+		if track.Kind != "asr" {
+			s += 2
+		}
+		if strings.HasPrefix(track.LanguageCode, "en") {
+			s++
+		}
+		if s > score && len(track.BaseURL) > 0 {
+			best, score = track, s
+		}
+	}
+	return best, score >= 0
+}
+
+// youtubeCaptions fetches a caption track in the timed text format
+func youtubeCaptions(trackURL string) ([]cue, error) {
+	link, err := url.Parse(trackURL)
+	if err != nil {
+		return nil, err
+	}
+	query := link.Query()
+	query.Del("fmt")
+	link.RawQuery = query.Encode()
+	data, status, err := fetchPage(link.String())
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, errors.New(http.StatusText(status))
+	}
+	return parseTimedText(data)
+}
+
+// parseTimedText reads the cues of timed text captions, the text is escaped
+// twice so entities are decoded once more
+func parseTimedText(data []byte) ([]cue, error) {
+	var transcript struct {
+		Texts []struct {
+			Start string `xml:"start,attr"`
+			Body  string `xml:",chardata"`
+		} `xml:"text"`
+	}
+	if err := xml.Unmarshal(data, &transcript); err != nil {
+		return nil, err
+	}
+	var cues []cue
+	for _, text := range transcript.Texts {
+		seconds, _ := strconv.ParseFloat(text.Start, 64)
+		body := strings.TrimSpace(htmlutil.UnescapeString(cueTag.ReplaceAllString(text.Body, "")))
+		if len(body) == 0 {
+			continue
+		}
+		cues = append(cues, cue{Start: time.Duration(seconds * float64(time.Second)), Text: strings.Join(strings.Fields(body), " ")})
+	}
+	return cues, nil
+}
+
+// youtubeArticle makes the article of a video with its thumbnail as lead
+// image, timestamps link back to the video. Videos without captions get
+// their description instead.
+func youtubeArticle(id string, player youtubePlayer, cues []cue, source string) Article {
+	details := player.VideoDetails
+	thumbnail := "https://i.ytimg.com/vi/" + id + "/hqdefault.jpg"
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf(`<figure><img src="%s" alt="%s"/></figure>`, thumbnail, htmlutil.EscapeString(details.Title)))
+	if len(cues) > 0 {
+		content.WriteString(renderTranscript(transcriptParagraphs(cues), func(at time.Duration) string {
+			return fmt.Sprintf("%s&t=%ds", source, int(at.Seconds()))
+		}))
+	} else {
+		util.Magenta.Printf("%s has no captions, only adding its description\n", details.Title)
+		content.WriteString("<p><em>This video has no transcript.</em></p>")
+		for _, para := range strings.Split(details.ShortDescription, "\n\n") {
+			if para = strings.TrimSpace(para); len(para) > 0 {
+				content.WriteString("<p>" + strings.ReplaceAll(htmlutil.EscapeString(para), "\n", "<br/>") + "</p>")
+			}
+		}
+	}
+	article := NewArticle(details.Title, details.Author, content.String(), source)
+	article.SiteName = "YouTube"
+	article.Image = thumbnail
+	article.Excerpt = truncateWords(details.ShortDescription, 200)
+	if date, err := time.Parse("2006-01-02", player.Microformat.Renderer.PublishDate); err == nil {
+		article.Published = date
+	} else if date, err := time.Parse(time.RFC3339, player.Microformat.Renderer.PublishDate); err == nil {
+		article.Published = date
+	}
+	return article
+}
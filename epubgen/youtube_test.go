@@ -0,0 +1,64 @@
+package epubgen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestYouTubeVideoID(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=10s": "dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ":                      "dQw4w9WgXcQ",
+		"https://m.youtube.com/shorts/dQw4w9WgXcQ":          "dQw4w9WgXcQ",
+		"https://www.youtube.com/embed/dQw4w9WgXcQ":         "dQw4w9WgXcQ",
+		"https://www.youtube.com/@channel/videos":           "",
+	} {
+		u, _ := url.Parse(link)
+		id, ok := youtubeVideoID(u)
+		if ok != (len(want) > 0) || (ok && id != want) {
+			t.Errorf("youtubeVideoID(%s) = %q, %v, want %q", link, id, ok, want)
+		}
+	}
+}
+
+func TestFetchYouTube(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/watch":
+			w.Write([]byte(`<html><script>var ytInitialPlayerResponse = {"videoDetails":{"title":"Engines","author":"Ada","shortDescription":"About engines"},
+"captions":{"playerCaptionsTracklistRenderer":{"captionTracks":[
+{"baseUrl":"` + server.URL + `/asr?fmt=srv3","languageCode":"en","kind":"asr"},
+{"baseUrl":"` + server.URL + `/captions?lang=de&fmt=srv3","languageCode":"de"}]}},
+"microformat":{"playerMicroformatRenderer":{"publishDate":"2024-03-05"}}};var meta = {};</script></html>`))
+		case "/captions":
+			if r.URL.Query().Get("fmt") != "" {
+				http.Error(w, "unexpected format", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8" ?><transcript><text start="0.5" dur="2">Guten &amp;#39;Tag&amp;#39;</text><text start="3" dur="2">zusammen</text></transcript>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(site string) { youtubeSite = site }(youtubeSite)
+	youtubeSite = server.URL
+
+	u, _ := url.Parse("https://youtu.be/dQw4w9WgXcQ")
+	article, err := fetchYouTube(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Engines" || article.Byline != "Ada" || article.Language != "de" ||
+		article.Image != "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg" || article.Published.Format("2006-01-02") != "2024-03-05" {
+		t.Errorf("unexpected article %+v", article)
+	}
+	if !strings.Contains(article.Content, `[0:00]</a></span> Guten &#39;Tag&#39; zusammen</p>`) ||
+		!strings.Contains(article.Content, "t=0s") {
+		t.Errorf("unexpected content %s", article.Content)
+	}
+}
@@ -0,0 +1,90 @@
+// Package feeds picks the items of subscribed feeds that haven't been
+// delivered yet
+package feeds
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nikhil1raghav/kindle-send/config"
+	"github.com/nikhil1raghav/kindle-send/epubgen"
+	"github.com/nikhil1raghav/kindle-send/util"
+)
+
+// DefaultMaxItems is how many new items of a feed go in a digest
+const DefaultMaxItems = 10
+
+// Batch is what a run delivers of a feed
+type Batch struct {
+	Feed config.Feed
+	// New items, oldest first
+	Items []epubgen.FeedItem
+	// Older new items left out of the digest, marked delivered with the
+	// others so they don't pile up
+	Skipped []string
+}
+
+// IDs returns the ids of the items delivered with the batch
+func (b Batch) IDs() []string {
+	ids := append([]string(nil), b.Skipped...)
+	for _, item := range b.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// NewItems fetches the feeds and returns the items of each not delivered
+// yet, the newest maxItems of them when there are more. Feeds that can't be
+// fetched are skipped.
+func NewItems(subscriptions []config.Feed, state *State, maxItems int, now time.Time) []Batch {
+	if maxItems <= 0 {
+		maxItems = DefaultMaxItems
+	}
+	var batches []Batch
+	for _, sub := range subscriptions {
+		feed, err := epubgen.FetchFeed(sub.URL)
+		if err != nil {
+			util.Red.Printf("SKIPPING feed %s : %s\n", sub.URL, err)
+			continue
+		}
+		batch := Batch{Feed: sub}
+		var ids []string
+		for _, item := range feed.Items {
+			ids = append(ids, item.ID)
+			if state.Delivered(sub.URL, item.ID) {
+				continue
+			}
+			if len(sub.Name) > 0 {
+				item.Feed = sub.Name
+			}
+			item.FetchArticle = sub.FetchArticles
+			batch.Items = append(batch.Items, item)
+		}
+		state.Seen(sub.URL, ids, now)
+
+		// Feeds list their newest items first, mostly
+		sort.SliceStable(batch.Items, func(i, j int) bool {
+			return batch.Items[i].Published.Before(batch.Items[j].Published)
+		})
+		if extra := len(batch.Items) - maxItems; extra > 0 {
+			for _, item := range batch.Items[:extra] {
+				batch.Skipped = append(batch.Skipped, item.ID)
+			}
+			batch.Items = batch.Items[extra:]
+			util.Magenta.Printf("%s has %d older new items, only the newest %d are delivered\n", title(feed, sub), extra, maxItems)
+		}
+		util.Cyan.Printf("%s : %d new items\n", title(feed, sub), len(batch.Items))
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func title(feed epubgen.Feed, sub config.Feed) string {
+	if len(sub.Name) > 0 {
+		return sub.Name
+	}
+	if len(feed.Title) > 0 {
+		return feed.Title
+	}
+	return sub.URL
+}
@@ -0,0 +1,66 @@
+package feeds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikhil1raghav/kindle-send/config"
+)
+
+const feedXML = `<?xml version="1.0"?><rss version="2.0"><channel><title>Notes</title>
+<item><title>Three</title><link>https://example.com/3</link><pubDate>Wed, 06 Mar 2024 10:00:00 +0000</pubDate></item>
+<item><title>Two</title><link>https://example.com/2</link><pubDate>Tue, 05 Mar 2024 10:00:00 +0000</pubDate></item>
+<item><title>One</title><link>https://example.com/1</link><pubDate>Mon, 04 Mar 2024 10:00:00 +0000</pubDate></item>
+</channel></rss>`
+
+func TestNewItems(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feedXML))
+	}))
+	defer server.Close()
+	subscriptions := []config.Feed{{URL: server.URL, Name: "My notes"}}
+	path := filepath.Join(t.TempDir(), "exports", StateFileName)
+	now := time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC)
+
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := NewItems(subscriptions, state, 2, now)
+	if len(batches) != 1 {
+		t.Fatalf("expected one batch, got %+v", batches)
+	}
+	var titles []string
+	for _, item := range batches[0].Items {
+		titles = append(titles, item.Title)
+		if item.Feed != "My notes" {
+			t.Errorf("expected the name of the subscription, got %q", item.Feed)
+		}
+	}
+	if strings.Join(titles, ",") != "Two,Three" || strings.Join(batches[0].Skipped, ",") != "https://example.com/1" {
+		t.Errorf("expected the two newest items oldest first, got %v skipping %v", titles, batches[0].Skipped)
+	}
+	state.MarkDelivered(server.URL, batches[0].IDs(), now)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches := NewItems(subscriptions, state, 2, now); len(batches[0].Items) != 0 || len(batches[0].Skipped) != 0 {
+		t.Errorf("expected nothing new on the second run, got %+v", batches)
+	}
+
+	// Items gone from the feed are forgotten after a while
+	state.MarkDelivered(server.URL, []string{"gone"}, now)
+	state.Seen(server.URL, nil, now.Add(forgetAfter+time.Hour))
+	if state.Delivered(server.URL, "gone") || state.Delivered(server.URL, "https://example.com/3") {
+		t.Error("expected items not seen for long to be forgotten")
+	}
+}
@@ -0,0 +1,110 @@
+package feeds
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFileName is the name of the file delivered items are tracked in,
+// kept next to exported.json in the exports directory
+const StateFileName = "feed-state.json"
+
+// Items gone from their feed are forgotten after this long, feeds only list
+// recent items so they won't come back
+const forgetAfter = 180 * 24 * time.Hour
+
+// State records the items of every feed already delivered
+type State struct {
+	path  string
+	Feeds map[string]*feedState `json:"feeds"`
+}
+
+type feedState struct {
+	LastRun time.Time `json:"last_run"`
+	// Ids of the delivered items and when they were last seen in the feed
+	Delivered map[string]time.Time `json:"delivered"`
+}
+
+// LoadState reads the state file at path, a missing file is an empty state
+func LoadState(path string) (*State, error) {
+	state := &State{path: path, Feeds: make(map[string]*feedState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Feeds == nil {
+		state.Feeds = make(map[string]*feedState)
+	}
+	return state, nil
+}
+
+func (s *State) feed(feedURL string) *feedState {
+	feed, ok := s.Feeds[feedURL]
+	if !ok {
+		feed = &feedState{Delivered: make(map[string]time.Time)}
+		s.Feeds[feedURL] = feed
+	}
+	if feed.Delivered == nil {
+		feed.Delivered = make(map[string]time.Time)
+	}
+	return feed
+}
+
+// Delivered reports whether the item with id of a feed was delivered
+func (s *State) Delivered(feedURL string, id string) bool {
+	_, ok := s.Feeds[feedURL].delivered()[id]
+	return ok
+}
+
+func (f *feedState) delivered() map[string]time.Time {
+	if f == nil {
+		return nil
+	}
+	return f.Delivered
+}
+
+// Seen records which items are still in a feed, delivered items that
+// haven't been seen for a long time are forgotten
+func (s *State) Seen(feedURL string, ids []string, now time.Time) {
+	feed := s.feed(feedURL)
+	for _, id := range ids {
+		if _, ok := feed.Delivered[id]; ok {
+			feed.Delivered[id] = now
+		}
+	}
+	for id, seen := range feed.Delivered {
+		if now.Sub(seen) > forgetAfter {
+			delete(feed.Delivered, id)
+		}
+	}
+}
+
+// MarkDelivered records items of a feed as delivered
+func (s *State) MarkDelivered(feedURL string, ids []string, now time.Time) {
+	feed := s.feed(feedURL)
+	feed.LastRun = now
+	for _, id := range ids {
+		feed.Delivered[id] = now
+	}
+}
+
+// Save writes the state back to its file
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
@@ -12,6 +12,7 @@ require (
 	github.com/gosimple/slug v1.13.1
 	github.com/lithammer/dedent v1.1.0
 	github.com/spf13/cobra v1.0.0
+	github.com/yuin/goldmark v1.8.2
 	golang.org/x/image v0.35.0
 	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8
 	gopkg.in/mail.v2 v2.3.1
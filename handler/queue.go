@@ -1,6 +1,11 @@
 package handler
 
 import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/nikhil1raghav/kindle-send/config"
 	"github.com/nikhil1raghav/kindle-send/epubgen"
 	"github.com/nikhil1raghav/kindle-send/mail"
@@ -11,31 +16,150 @@ import (
 func Queue(downloadRequests []types.Request) []types.Request {
 	var processedRequests []types.Request
 	for _, req := range downloadRequests {
-		switch req.Type {
-		case types.TypeFile:
+		if req.Type == types.TypeFile {
 			processedRequests = append(processedRequests, req)
 			continue
-		case types.TypeUrl:
-			path, err := epubgen.Make([]string{req.Path}, "")
+		}
+
+		opts, err := bookOptions(req.Options)
+		if err != nil {
+			util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
+			continue
+		}
+
+		var links []string
+		var title string
+		switch req.Type {
+		case types.TypeRepo:
+			path, err := epubgen.MakeFromRepo(req.Path, "", opts)
 			if err != nil {
 				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
 			} else {
 				processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
 			}
-		case types.TypeUrlFile:
-			links := util.ExtractLinks(req.Path)
-			path, err := epubgen.Make(links, "")
+			continue
+		case types.TypeTranscript:
+			path, err := epubgen.MakeFromTranscript(req.Path, "", opts)
 			if err != nil {
 				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
 			} else {
 				processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
 			}
+			continue
+		case types.TypeSubstack:
+			paths, err := epubgen.MakeFromSubstack(req.Path, substackArchive(req.Options), "", opts)
+			if err != nil {
+				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
+			}
+			for _, path := range paths {
+				processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
+			}
+			continue
+		case types.TypeCrawl:
+			crawl, err := crawlOptions(req.Options)
+			if err == nil {
+				links, title, err = epubgen.Crawl(req.Path, crawl)
+			}
+			if err != nil {
+				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
+				continue
+			}
+		case types.TypeUrl:
+			links = []string{req.Path}
+		case types.TypeUrlFile:
+			links = util.ExtractLinks(req.Path)
+		default:
+			continue
+		}
+
+		// PDFs like papers without an HTML version are sent as they are
+		links, files := epubgen.SplitPassThrough(links)
+		for _, file := range files {
+			path, err := epubgen.DownloadFile(file, "")
+			if err != nil {
+				util.Red.Printf("SKIPPING %s : %s\n", file.URL, err)
+				continue
+			}
+			processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
+		}
+		if len(links) == 0 {
+			continue
+		}
+		path, err := epubgen.Make(links, title, opts)
+		if err != nil {
+			util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
+		} else {
+			processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
 		}
 	}
 	return processedRequests
 }
 
-func Mail(mailRequests []types.Request, timeout int) {
+// bookOptions reads the ebook options of a request
+func bookOptions(options map[string]string) (epubgen.Options, error) {
+	opts, err := epubgen.NewOptions(options[types.OptionProfile])
+	if err != nil {
+		return opts, err
+	}
+	opts.Cover = options[types.OptionCover]
+	opts.TOCDepth, _ = strconv.Atoi(options[types.OptionTOCDepth])
+	if theme := options[types.OptionTheme]; len(theme) > 0 {
+		opts.Theme = theme
+	}
+	opts.Endnotes = options[types.OptionEndnotes]
+	if format := options[types.OptionFormat]; len(format) > 0 {
+		opts.Format = format
+	}
+	if tags := options[types.OptionTags]; len(tags) > 0 {
+		opts.Tags = strings.Split(tags, ",")
+	}
+	opts.PageSize = options[types.OptionPageSize]
+	opts.FontSize, _ = strconv.ParseFloat(options[types.OptionFontSize], 64)
+	return opts, nil
+}
+
+// Digest builds a book of feed items with the ebook options of a request
+func Digest(items []epubgen.FeedItem, title string, options map[string]string) (types.Request, error) {
+	opts, err := bookOptions(options)
+	if err != nil {
+		return types.Request{}, err
+	}
+	path, err := epubgen.MakeDigest(items, title, "", opts)
+	if err != nil {
+		return types.Request{}, err
+	}
+	return types.NewRequest(path, types.TypeFile, nil), nil
+}
+
+// substackArchive reads the date range of an archive from request options,
+// the newest date is kept whole
+func substackArchive(options map[string]string) epubgen.SubstackArchive {
+	archive := epubgen.SubstackArchive{PerYear: options[types.OptionPerYear] == "true"}
+	if since, err := time.Parse(time.DateOnly, options[types.OptionSince]); err == nil {
+		archive.Since = since
+	}
+	if until, err := time.Parse(time.DateOnly, options[types.OptionUntil]); err == nil {
+		archive.Until = until.AddDate(0, 0, 1)
+	}
+	return archive
+}
+
+// crawlOptions reads how links are collected from an index from request options
+func crawlOptions(options map[string]string) (epubgen.CrawlOptions, error) {
+	crawl := epubgen.CrawlOptions{Selector: options[types.OptionSelect], Order: options[types.OptionOrder]}
+	crawl.Limit, _ = strconv.Atoi(options[types.OptionLimit])
+	if match := options[types.OptionMatch]; len(match) > 0 {
+		pattern, err := regexp.Compile(match)
+		if err != nil {
+			return crawl, err
+		}
+		crawl.Match = pattern
+	}
+	return crawl, nil
+}
+
+// Mail sends the files of requests to the ereader, returns why it couldn't
+func Mail(mailRequests []types.Request, timeout int) error {
 	var filePaths []string
 	for _, req := range mailRequests {
 		filePaths = append(filePaths, req.Path)
@@ -43,5 +167,5 @@ func Mail(mailRequests []types.Request, timeout int) {
 	if timeout < 60 {
 		timeout = config.DefaultTimeout
 	}
-	mail.Send(filePaths, timeout)
+	return mail.Send(filePaths, timeout)
 }
@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"errors"
 	"github.com/nikhil1raghav/kindle-send/util"
 	"os"
 	"time"
@@ -10,7 +11,8 @@ import (
 	gomail "gopkg.in/mail.v2"
 )
 
-func Send(files []string, timeout int) {
+// Send mails the files to the ereader, returns why it couldn't
+func Send(files []string, timeout int) error {
 	cfg := config.GetInstance()
 	msg := gomail.NewMessage()
 	msg.SetHeader("From", cfg.Sender)
@@ -30,8 +32,7 @@ func Send(files []string, timeout int) {
 		}
 	}
 	if len(attachedFiles) == 0 {
-		util.Cyan.Println("No files to send")
-		return
+		return errors.New("no files to send")
 	}
 
 	dialer := gomail.NewDialer(cfg.Server, cfg.Port, cfg.Sender, cfg.Password)
@@ -44,10 +45,10 @@ func Send(files []string, timeout int) {
 	}
 
 	if err := dialer.DialAndSend(msg); err != nil {
-		util.Red.Println("Error sending mail : ", err)
-		return
+		return err
 	} else {
 		util.GreenBold.Printf("Mailed %d files to %s", len(attachedFiles), cfg.Receiver)
 	}
+	return nil
 
 }
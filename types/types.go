@@ -3,9 +3,13 @@ package types
 type FileType string
 
 var (
-	TypeUrl     FileType = "url"
-	TypeUrlFile FileType = "urlfile"
-	TypeFile    FileType = "file"
+	TypeUrl        FileType = "url"
+	TypeUrlFile    FileType = "urlfile"
+	TypeFile       FileType = "file"
+	TypeRepo       FileType = "repo"       // GitHub repository or local clone whose docs make a book
+	TypeTranscript FileType = "transcript" // Local WebVTT or SubRip file
+	TypeSubstack   FileType = "substack"   // Substack publication whose archive makes books
+	TypeCrawl      FileType = "crawl"      // Index page or sitemap whose article links make a book
 )
 
 type Request struct {
@@ -17,3 +21,23 @@ type Request struct {
 func NewRequest(path string, fileType FileType, opts map[string]string) Request {
 	return Request{path, fileType, opts}
 }
+
+// Keys of Request.Options
+const (
+	OptionProfile  = "profile"   // Device profile used to build ebooks
+	OptionCover    = "cover"     // Image file used as cover of ebooks
+	OptionTOCDepth = "toc-depth" // Heading levels listed in the table of contents
+	OptionTheme    = "theme"     // Reading theme of ebooks
+	OptionEndnotes = "endnotes"  // Where external links are collected as endnotes
+	OptionFormat   = "format"    // Output format of ebooks
+	OptionTags     = "tags"      // Comma separated tags of Markdown notes
+	OptionPageSize = "page-size" // Page size of PDF books
+	OptionFontSize = "font-size" // Font size of PDF books in points
+	OptionSince    = "since"     // Oldest publication date kept, YYYY-MM-DD
+	OptionUntil    = "until"     // Newest publication date kept, YYYY-MM-DD
+	OptionPerYear  = "per-year"  // "true" to make one book per year of an archive
+	OptionSelect   = "select"    // CSS selector of the links of an index page
+	OptionMatch    = "match"     // Regular expression crawled links must match
+	OptionLimit    = "limit"     // Most links kept from an index page or sitemap
+	OptionOrder    = "order"     // Order of crawled links
+)
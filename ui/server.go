@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/nikhil1raghav/kindle-send/config"
 	"github.com/nikhil1raghav/kindle-send/cookies"
 	"github.com/nikhil1raghav/kindle-send/epubgen"
 	"github.com/nikhil1raghav/kindle-send/util"
@@ -27,8 +28,16 @@ var exportedFilePath string
 var manualFilePath string
 
 type convertRequest struct {
-	URLs  []string `json:"urls"`
-	Title string   `json:"title"`
+	URLs     []string `json:"urls"`
+	Title    string   `json:"title"`
+	Profile  string   `json:"profile,omitempty"`
+	TOCDepth int      `json:"toc_depth,omitempty"`
+	Theme    string   `json:"theme,omitempty"`
+	Endnotes string   `json:"endnotes,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	PageSize string   `json:"page_size,omitempty"`
+	FontSize float64  `json:"font_size,omitempty"`
 }
 
 type convertResponse struct {
@@ -71,6 +80,12 @@ type manualResponse struct {
 	Error   string          `json:"error,omitempty"`
 }
 
+type profilesResponse struct {
+	Success  bool     `json:"success"`
+	Profiles []string `json:"profiles"`
+	Default  string   `json:"default"`
+}
+
 type pendingResponse struct {
 	Success bool           `json:"success"`
 	URLs    []pendingEntry `json:"urls,omitempty"`
@@ -104,6 +119,7 @@ func StartServer(port int, exportDir string, cookiesFile string) error {
 	http.HandleFunc("/open-folder", handleOpenFolder)
 	http.HandleFunc("/pending", handlePending)
 	http.HandleFunc("/manual", handleManual)
+	http.HandleFunc("/profiles", handleProfiles)
 
 	addr := fmt.Sprintf(":%d", port)
 	util.CyanBold.Printf("Starting server at http://localhost%s\n", addr)
@@ -131,6 +147,26 @@ func handleConvert(exportDir string) http.HandlerFunc {
 			return
 		}
 
+		opts, err := epubgen.NewOptions(req.Profile)
+		if err != nil {
+			json.NewEncoder(w).Encode(convertResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		opts.TOCDepth = req.TOCDepth
+		if len(req.Theme) > 0 {
+			opts.Theme = req.Theme
+		}
+		opts.Endnotes = req.Endnotes
+		if len(req.Format) > 0 {
+			opts.Format = req.Format
+		}
+		opts.Tags = req.Tags
+		opts.PageSize = req.PageSize
+		opts.FontSize = req.FontSize
+
 		// Get manual articles
 		manualArticles := loadManualArticles()
 		var epubManualArticles []epubgen.ManualArticle
@@ -151,7 +187,7 @@ func handleConvert(exportDir string) http.HandlerFunc {
 		}
 
 		// Generate EPUB with URLs and manual articles
-		epubPath, err := epubgen.MakeToDirWithManual(req.URLs, epubManualArticles, req.Title, exportDir)
+		epubPath, err := epubgen.MakeToDirWithManual(req.URLs, epubManualArticles, req.Title, exportDir, opts)
 		if err != nil {
 			json.NewEncoder(w).Encode(convertResponse{
 				Success: false,
@@ -173,6 +209,20 @@ func handleConvert(exportDir string) http.HandlerFunc {
 	}
 }
 
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	def := config.DefaultProfile
+	if config.GetInstance() != nil && config.GetInstance().Profile != "" {
+		def = config.GetInstance().Profile
+	}
+	json.NewEncoder(w).Encode(profilesResponse{
+		Success:  true,
+		Profiles: config.ProfileNames(),
+		Default:  def,
+	})
+}
+
 func handleCookies(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -1,24 +1,27 @@
 package util
 
 import (
-	"fmt"
 	"strconv"
 )
 
+// GetHash returns the murmur hash of name, safe to use in file names
 func GetHash(name string) string{
 	hash:=murmurHash64B([]byte(name), 0)
-	hashStr:=strconv.Itoa(int(hash))
+	return strconv.FormatUint(hash, 10)
+}
 
-	//TODO: this works but is functionally incorrect, assign mimetype based on content
-	return fmt.Sprintf("img%s.png",hashStr)
+// HashBytes returns the murmur hash of content as a string, used to find identical files
+func HashBytes(content []byte) string{
+	return strconv.FormatUint(murmurHash64B(content, 0), 16)
 }
+
 func murmurHash64B(key []byte, seed uint64) (hash uint64) {
 	const m uint32 = 0x5bd1e995
 	const r = 24
 
 	var l int = len(key)
 	var h1 uint32 = uint32(seed) ^ uint32(l)
-	var h2 uint32 = uint32(seed) >> 32
+	var h2 uint32 = uint32(seed >> 32)
 
 	var data []byte = key
 